@@ -1,8 +1,10 @@
 package adc
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync"
 
 	"github.com/SpaceLeap/go-embedded"
 )
@@ -24,42 +26,95 @@ var (
 	prefixDir  string
 )
 
+var initMutex sync.Mutex
+
+// differentialADCs are the ADCs opened by ReadDifferential,
+// guarded by initMutex and closed by Cleanup.
+var differentialADCs map[Name]*ADC
+
+// Init loads the ADC device tree overlay and resolves the
+// sysfs directory of the analog inputs.
+// Calling Init again with the same deviceTreePrefix is a no-op,
+// calling it with a different one returns an error until Cleanup is called.
 func Init(deviceTreePrefix string) error {
+	initMutex.Lock()
+	defer initMutex.Unlock()
+
+	if prefixDir != "" {
+		if deviceTreePrefix != deviceTree {
+			return fmt.Errorf("ADC already initialized with device tree %s, can't initialize with %s", deviceTree, deviceTreePrefix)
+		}
+		return nil
+	}
+
 	err := embedded.LoadDeviceTree(deviceTreePrefix)
 	if err != nil {
 		return err
 	}
-	deviceTree = deviceTreePrefix
 
-	ocpDir, err := embedded.BuildPath("/sys/devices", "ocp")
+	ocpDir, err := embedded.BuildPath(embedded.SysDevicesBase, "ocp")
 	if err != nil {
 		return err
 	}
-	prefixDir, err = embedded.BuildPath(ocpDir, "helper")
+	ctx, cancel := context.WithTimeout(context.Background(), embedded.DeviceTreeTimeout)
+	defer cancel()
+	helperDir, err := embedded.WaitForPathGlob(ctx, ocpDir, "helper")
 	if err != nil {
 		return err
 	}
-	prefixDir += "/AIN"
+	deviceTree = deviceTreePrefix
+	prefixDir = helperDir + "/AIN"
 	return nil
 }
 
 func Cleanup() error {
-	return embedded.UnloadDeviceTree(deviceTree)
+	initMutex.Lock()
+	defer initMutex.Unlock()
+
+	if prefixDir == "" {
+		return nil
+	}
+	for _, adc := range differentialADCs {
+		adc.Close()
+	}
+	differentialADCs = nil
+	err := embedded.UnloadDeviceTree(deviceTree)
+	if err != nil {
+		return err
+	}
+	deviceTree = ""
+	prefixDir = ""
+	return nil
 }
 
+// ADC reads an analog input.
+// It is safe to read from multiple goroutines and to share
+// with a PeakTracker, the reads and the filter state
+// of ReadFiltered are guarded by an internal mutex.
 type ADC struct {
-	ain  Name
-	file *os.File
+	ain   Name
+	file  *os.File
+	mutex sync.Mutex
+	ema   float32 // state of ReadFiltered
+	emaOk bool
 }
 
 func NewADC(ain Name) (*ADC, error) {
+	initMutex.Lock()
+	defer initMutex.Unlock()
+
+	return openADC(ain)
+}
+
+// openADC opens the sysfs file of ain, the caller must hold initMutex.
+func openADC(ain Name) (*ADC, error) {
 	filename := prefixDir + string(ain)
 	file, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ADC{ain, file}, nil
+	return &ADC{ain: ain, file: file}, nil
 }
 
 func (adc *ADC) Close() error {
@@ -71,11 +126,109 @@ func (adc *ADC) AIn() Name {
 }
 
 func (adc *ADC) ReadRaw() (value float32) {
-	adc.file.Seek(0, os.SEEK_SET)
-	fmt.Fscan(adc.file, &value)
-	return value
+	raw, _ := adc.ReadRawInt()
+	return float32(raw)
+}
+
+// ReadRawInt reads the raw integer count of the ADC
+// without the precision loss of ReadRaw.
+func (adc *ADC) ReadRawInt() (int, error) {
+	adc.mutex.Lock()
+	defer adc.mutex.Unlock()
+
+	return adc.readRawInt()
+}
+
+// readRawInt reads the raw count, the caller must hold the mutex.
+func (adc *ADC) readRawInt() (int, error) {
+	return embedded.ReadSysfsFileInt(adc.file)
+}
+
+// Healthy checks if the ADC can be read.
+func (adc *ADC) Healthy() error {
+	_, err := adc.ReadRawInt()
+	return err
 }
 
 func (adc *ADC) ReadValue() (value float32) {
 	return adc.ReadRaw() / 1800.0
 }
+
+// ReadFiltered reads the value scaled like ReadValue and returns
+// its exponential moving average over all ReadFiltered calls:
+// ema = alpha*sample + (1-alpha)*ema, starting with the first sample.
+// alpha must be greater than 0 and at most 1,
+// smaller values smooth more but follow changes slower.
+func (adc *ADC) ReadFiltered(alpha float32) (float32, error) {
+	if alpha <= 0 || alpha > 1 {
+		return 0, fmt.Errorf("ADC filter alpha %g must be greater than 0 and at most 1", alpha)
+	}
+	// the read and the update are one critical section,
+	// so concurrent callers apply their samples in read order
+	adc.mutex.Lock()
+	defer adc.mutex.Unlock()
+
+	raw, err := adc.readRawInt()
+	if err != nil {
+		return 0, err
+	}
+	sample := float32(raw) / 1800.0
+	if adc.emaOk {
+		adc.ema = alpha*sample + (1-alpha)*adc.ema
+	} else {
+		adc.ema = sample
+		adc.emaOk = true
+	}
+	return adc.ema, nil
+}
+
+// ReadDifferential returns the scaled value of the input a minus the input b,
+// for example to read a Wheatstone bridge. An error reading either of them
+// is returned. The inputs are opened on the first call and kept open
+// for the following calls until Cleanup.
+// The channels are read one after the other and not simultaneously,
+// so a signal changing between the two reads shows up in the result.
+func ReadDifferential(a, b Name) (float32, error) {
+	adcA, err := differentialADC(a)
+	if err != nil {
+		return 0, err
+	}
+	adcB, err := differentialADC(b)
+	if err != nil {
+		return 0, err
+	}
+	return ReadDifferentialADC(adcA, adcB)
+}
+
+// differentialADC returns the cached ADC of ain, opening it on first use.
+func differentialADC(ain Name) (*ADC, error) {
+	initMutex.Lock()
+	defer initMutex.Unlock()
+
+	if adc, ok := differentialADCs[ain]; ok {
+		return adc, nil
+	}
+	adc, err := openADC(ain)
+	if err != nil {
+		return nil, err
+	}
+	if differentialADCs == nil {
+		differentialADCs = make(map[Name]*ADC)
+	}
+	differentialADCs[ain] = adc
+	return adc, nil
+}
+
+// ReadDifferentialADC works like ReadDifferential
+// with ADCs opened by the caller.
+func ReadDifferentialADC(a, b *ADC) (float32, error) {
+	rawA, err := a.ReadRawInt()
+	if err != nil {
+		return 0, err
+	}
+	rawB, err := b.ReadRawInt()
+	if err != nil {
+		return 0, err
+	}
+	return float32(rawA-rawB) / 1800.0, nil
+}
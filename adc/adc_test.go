@@ -0,0 +1,263 @@
+package adc
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SpaceLeap/go-embedded"
+)
+
+// fakeADC returns an ADC reading a temporary file with content
+// instead of the sysfs file of ain.
+func fakeADC(t *testing.T, ain Name, content string) *ADC {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "AIN"+string(ain)), []byte(content), 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := prefixDir
+	prefixDir = filepath.Join(dir, "AIN")
+	t.Cleanup(func() { prefixDir = saved })
+
+	adc, err := NewADC(ain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { adc.Close() })
+	return adc
+}
+
+// TestConcurrentReads is meant to be run with -race.
+func TestConcurrentReads(t *testing.T) {
+	adc := fakeADC(t, AIN0, "1234\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tracker := NewPeakTracker(ctx, adc, 100*time.Microsecond, 4)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				raw, err := adc.ReadRawInt()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if raw != 1234 {
+					t.Errorf("ReadRawInt returned %d", raw)
+				}
+				if raw := adc.ReadRaw(); raw != 1234 {
+					t.Errorf("ReadRaw returned %g", raw)
+				}
+				if _, err := adc.ReadFiltered(0.5); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if peak, ok := tracker.Peak(); ok {
+			if peak != 1234 {
+				t.Errorf("Peak returned %d", peak)
+			}
+			return
+		}
+	}
+	t.Error("PeakTracker took no sample")
+}
+
+func TestReadRawInt(t *testing.T) {
+	tests := []struct {
+		content string
+		raw     int
+		ok      bool
+	}{
+		{"0\n", 0, true},
+		{"1\n", 1, true},
+		{"4095\n", 4095, true},
+		{"65535\n", 65535, true},
+		{"16777217\n", 16777217, true}, // not exact as float32
+		{"", 0, false},
+		{"\n", 0, false},
+		{"12x\n", 0, false},
+		{"40.95\n", 0, false},
+	}
+	for _, test := range tests {
+		adc := fakeADC(t, AIN0, test.content)
+		raw, err := adc.ReadRawInt()
+		if (err == nil) != test.ok || raw != test.raw {
+			t.Errorf("ReadRawInt of %q = %d, %v", test.content, raw, err)
+		}
+	}
+}
+
+func TestReadFiltered(t *testing.T) {
+	adc := fakeADC(t, AIN0, "900\n")
+	for _, alpha := range []float32{0, -0.5, 1.5} {
+		if _, err := adc.ReadFiltered(alpha); err == nil {
+			t.Errorf("ReadFiltered accepted alpha %g", alpha)
+		}
+	}
+	// the first sample initializes the average
+	value, err := adc.ReadFiltered(0.25)
+	if err != nil || value != 0.5 {
+		t.Fatalf("first ReadFiltered = %g, %v instead of 0.5", value, err)
+	}
+
+	// a step to 1800 is approached by 1-(1-alpha)^n of the difference
+	if err = os.WriteFile(adc.file.Name(), []byte("1800\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	expected := []float32{0.625, 0.71875, 0.7890625, 0.8417969}
+	for i, e := range expected {
+		value, err = adc.ReadFiltered(0.25)
+		if err != nil || math.Abs(float64(value-e)) > 1e-6 {
+			t.Errorf("ReadFiltered %d after the step = %g, %v instead of %g", i+1, value, err, e)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		value, err = adc.ReadFiltered(0.25)
+	}
+	if err != nil || math.Abs(float64(value-1)) > 1e-6 {
+		t.Errorf("ReadFiltered converged to %g, %v instead of 1", value, err)
+	}
+
+	// alpha 1 disables the filter
+	if err = os.WriteFile(adc.file.Name(), []byte("0\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if value, err = adc.ReadFiltered(1); err != nil || value != 0 {
+		t.Errorf("ReadFiltered with alpha 1 = %g, %v instead of 0", value, err)
+	}
+}
+
+func TestReadDifferentialADC(t *testing.T) {
+	a := fakeADC(t, AIN0, "1000\n")
+	b := fakeADC(t, AIN1, "100\n")
+	invalid := fakeADC(t, AIN2, "\n")
+
+	diff, err := ReadDifferentialADC(a, b)
+	if err != nil || diff != 0.5 {
+		t.Errorf("ReadDifferentialADC = %g, %v", diff, err)
+	}
+	diff, err = ReadDifferentialADC(b, a)
+	if err != nil || diff != -0.5 {
+		t.Errorf("ReadDifferentialADC = %g, %v", diff, err)
+	}
+	if _, err = ReadDifferentialADC(a, invalid); err == nil {
+		t.Error("ReadDifferentialADC ignored the read error of b")
+	}
+	if _, err = ReadDifferentialADC(invalid, b); err == nil {
+		t.Error("ReadDifferentialADC ignored the read error of a")
+	}
+}
+
+func TestReadDifferential(t *testing.T) {
+	dir := t.TempDir()
+	writeInput := func(ain Name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, "AIN"+string(ain)), []byte(content), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeInput(AIN0, "1000\n")
+	writeInput(AIN1, "100\n")
+	saved := prefixDir
+	prefixDir = filepath.Join(dir, "AIN")
+	t.Cleanup(func() {
+		for _, adc := range differentialADCs {
+			adc.Close()
+		}
+		differentialADCs = nil
+		prefixDir = saved
+	})
+
+	diff, err := ReadDifferential(AIN0, AIN1)
+	if err != nil || diff != 0.5 {
+		t.Errorf("ReadDifferential = %g, %v", diff, err)
+	}
+	// the open handles are reused and read the new value
+	writeInput(AIN0, "1900\n")
+	diff, err = ReadDifferential(AIN0, AIN1)
+	if err != nil || diff != 1 {
+		t.Errorf("ReadDifferential = %g, %v after changing a", diff, err)
+	}
+	if len(differentialADCs) != 2 {
+		t.Errorf("%d cached ADCs instead of 2", len(differentialADCs))
+	}
+	if _, err = ReadDifferential(AIN0, AIN5); err == nil {
+		t.Error("ReadDifferential ignored the missing input b")
+	}
+}
+
+// fakeCapemgr creates a sysfs tree with a capemgr slots file
+// and the ocp helper directory of the ADC overlay.
+func fakeCapemgr(t *testing.T) (slotsFile string) {
+	dir := t.TempDir()
+	for _, sub := range []string{"bone_capemgr.9", "ocp.3/helper.15"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0770); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slotsFile = filepath.Join(dir, "bone_capemgr.9", "slots")
+	if err := os.WriteFile(slotsFile, []byte(" 0: 54:PF--- \n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	saved := embedded.SysDevicesBase
+	embedded.SysDevicesBase = dir
+	t.Cleanup(func() { embedded.SysDevicesBase = saved })
+	if err := embedded.Init("bone_capemgr"); err != nil {
+		t.Fatal(err)
+	}
+	return slotsFile
+}
+
+func TestInitTwice(t *testing.T) {
+	slotsFile := fakeCapemgr(t)
+	t.Cleanup(func() { deviceTree, prefixDir = "", "" })
+
+	if err := Init("cape-bone-iio"); err != nil {
+		t.Fatal(err)
+	}
+	dir := prefixDir
+	if filepath.Base(filepath.Dir(dir)) != "helper.15" {
+		t.Errorf("prefix directory is %s", dir)
+	}
+	if err := Init("cape-bone-iio"); err != nil {
+		t.Errorf("second Init with the same device tree returned %v", err)
+	}
+	if err := Init("BB-ADC"); err == nil {
+		t.Error("Init with a conflicting device tree returned no error")
+	}
+	if prefixDir != dir || deviceTree != "cape-bone-iio" {
+		t.Errorf("Init changed the state to %s, %s", deviceTree, prefixDir)
+	}
+
+	// the capemgr lists the loaded overlay
+	slots := " 0: 54:PF--- \n 7: ff:P-O-L Override Board Name,00A0,Override Manuf,cape-bone-iio\n"
+	if err := os.WriteFile(slotsFile, []byte(slots), 0660); err != nil {
+		t.Fatal(err)
+	}
+	embedded.RefreshDeviceTreeCache()
+	if err := Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init("BB-ADC"); err != nil {
+		t.Errorf("Init after Cleanup returned %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+package adc
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// PeakTracker samples an ADC in the background and tracks the minimum
+// and maximum raw value of the last samples within a rolling window,
+// for example for a VU meter display.
+// The samples are kept in a ring buffer of the window size.
+type PeakTracker struct {
+	adc     *ADC
+	mutex   sync.Mutex
+	samples []int
+	next    int
+	full    bool
+	err     error
+}
+
+// NewPeakTracker starts sampling adc every interval until ctx is done,
+// tracking the last window samples.
+func NewPeakTracker(ctx context.Context, adc *ADC, interval time.Duration, window int) *PeakTracker {
+	if window < 1 {
+		window = 1
+	}
+	tracker := &PeakTracker{adc: adc, samples: make([]int, window)}
+	go tracker.run(ctx, interval)
+	return tracker
+}
+
+func (tracker *PeakTracker) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			value, err := tracker.adc.ReadRawInt()
+			tracker.mutex.Lock()
+			tracker.err = err
+			if err == nil {
+				tracker.samples[tracker.next] = value
+				tracker.next = (tracker.next + 1) % len(tracker.samples)
+				tracker.full = tracker.full || tracker.next == 0
+			}
+			tracker.mutex.Unlock()
+		}
+	}
+}
+
+// window returns the samples in the window, the caller must hold the mutex.
+func (tracker *PeakTracker) window() []int {
+	if tracker.full {
+		return tracker.samples
+	}
+	return tracker.samples[:tracker.next]
+}
+
+// Peak returns the maximum raw value within the window.
+// ok is false if no sample was taken yet since the start or Reset.
+func (tracker *PeakTracker) Peak() (peak int, ok bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for i, value := range tracker.window() {
+		if i == 0 || value > peak {
+			peak = value
+		}
+		ok = true
+	}
+	return peak, ok
+}
+
+// Min returns the minimum raw value within the window.
+// ok is false if no sample was taken yet since the start or Reset.
+func (tracker *PeakTracker) Min() (min int, ok bool) {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	for i, value := range tracker.window() {
+		if i == 0 || value < min {
+			min = value
+		}
+		ok = true
+	}
+	return min, ok
+}
+
+// Reset discards all samples of the window.
+func (tracker *PeakTracker) Reset() {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.next = 0
+	tracker.full = false
+}
+
+// Err returns the error of the last sample read, or nil if it succeeded.
+func (tracker *PeakTracker) Err() error {
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.err
+}
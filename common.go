@@ -2,83 +2,324 @@ package embedded
 
 import (
 	"bufio"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"github.com/ungerik/go-dry"
 )
 
 var ctrlDir string
 
+// SysDevicesBase is the base directory of the device discovery
+// by Init and the sub-packages. It can be changed before Init
+// for boards or containers with a nonstandard sysfs mount
+// or to run against a mocked sysfs tree.
+var SysDevicesBase = "/sys/devices"
+
+// SlotsCacheDuration is how long the content of the capemgr slots file
+// is cached by IsDeviceTreeLoaded. Loading or unloading a device tree
+// overlay with this package invalidates the cache.
+var SlotsCacheDuration = time.Second
+
+var (
+	slotsMutex     sync.Mutex
+	slotsCache     []Slot
+	slotsCacheTime time.Time
+)
+
+// DeviceTreeTimeout is the time constructors wait for device nodes
+// to appear after loading a device tree overlay.
+var DeviceTreeTimeout = 2 * time.Second
+
+const waitPollInterval = 10 * time.Millisecond
+
+// Init finds the capemgr directory starting with devicesDir
+// in SysDevicesBase.
 func Init(devicesDir string) error {
-	dir, err := BuildPath("/sys/devices", devicesDir)
+	dir, err := BuildPath(SysDevicesBase, devicesDir)
 	if err != nil {
 		return err
 	}
 	ctrlDir = dir
+	RefreshDeviceTreeCache()
 	return nil
 }
 
+// BuildPath returns the first directory in partialPath
+// starting with prefix, see BuildPaths.
 func BuildPath(partialPath, prefix string) (string, error) {
-	dirFiles, err := ioutil.ReadDir(partialPath)
+	paths, err := BuildPaths(partialPath, prefix)
 	if err != nil {
 		return "", err
 	}
+	if len(paths) == 0 {
+		return "", os.ErrNotExist
+	}
+	return paths[0], nil
+}
+
+// BuildPaths returns all directories in partialPath
+// starting with prefix, sorted by name.
+func BuildPaths(partialPath, prefix string) ([]string, error) {
+	dirFiles, err := ioutil.ReadDir(partialPath)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
 	for _, file := range dirFiles {
 		if file.IsDir() && strings.HasPrefix(file.Name(), prefix) {
-			return path.Join(partialPath, file.Name()), nil
+			paths = append(paths, path.Join(partialPath, file.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// WaitForPath polls until path exists or ctx is done.
+func WaitForPath(ctx context.Context, path string) error {
+	for {
+		_, err := os.Stat(path)
+		if err == nil {
+			return nil
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for %s: %w", path, ctx.Err())
+		case <-time.After(waitPollInterval):
+		}
+	}
+}
+
+// WaitForPathGlob polls until BuildPath finds a directory
+// in partialPath starting with prefix, or ctx is done.
+func WaitForPathGlob(ctx context.Context, partialPath, prefix string) (string, error) {
+	for {
+		dir, err := BuildPath(partialPath, prefix)
+		if err == nil {
+			return dir, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("waiting for %s/%s*: %w", partialPath, prefix, ctx.Err())
+		case <-time.After(waitPollInterval):
+		}
+	}
+}
+
+// Slot is an entry of the capemgr slots file.
+type Slot struct {
+	Index int
+	// Flags of the slot like "P-O-L", without the EEPROM address
+	// in front of them
+	Flags string
+	// Name is empty for slots without a loaded cape or overlay,
+	// otherwise it is the comma separated list of board name,
+	// version, manufacturer and part number
+	Name string
+}
+
+// ParseSlots parses the content of the capemgr slots file
+// with lines like:
+//
+//	0: 54:PF---
+//	4: ff:P-O-L Override Board Name,00A0,Override Manuf,BB-ADC
+func ParseSlots(r io.Reader) ([]Slot, error) {
+	var slots []Slot
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid slots line %q", line)
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(line[:colon]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid slots line %q: %w", line, err)
+		}
+		rest := strings.TrimSpace(line[colon+1:])
+		// skip the EEPROM address
+		if colon = strings.IndexByte(rest, ':'); colon >= 0 {
+			rest = rest[colon+1:]
+		}
+		slot := Slot{Index: index, Flags: rest}
+		if space := strings.IndexByte(rest, ' '); space >= 0 {
+			slot.Flags = rest[:space]
+			slot.Name = strings.TrimSpace(rest[space+1:])
+		}
+		slots = append(slots, slot)
+	}
+	return slots, scanner.Err()
+}
+
+func readSlots() ([]Slot, error) {
+	slotsMutex.Lock()
+	defer slotsMutex.Unlock()
+
+	if !slotsCacheTime.IsZero() && time.Since(slotsCacheTime) < SlotsCacheDuration {
+		return slotsCache, nil
+	}
+	data, err := ReadSysfsString(ctrlDir + "/slots")
+	if err != nil {
+		return nil, err
+	}
+	slots, err := ParseSlots(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	slotsCache = slots
+	slotsCacheTime = time.Now()
+	return slots, nil
+}
+
+// findSlot returns the first slot whose name contains name.
+func findSlot(slots []Slot, name string) (Slot, bool) {
+	for _, slot := range slots {
+		if strings.Contains(slot.Name, name) {
+			return slot, true
 		}
 	}
-	return "", os.ErrNotExist
+	return Slot{}, false
+}
+
+// writeSlots writes a command to the capemgr slots file.
+// It is a variable so that tests can emulate the capemgr,
+// whose slots file lists the slots instead of the written command.
+var writeSlots = func(command string) error {
+	return WriteSysfsString(ctrlDir+"/slots", command)
+}
+
+// RefreshDeviceTreeCache invalidates the cached content of the slots file,
+// so that the next IsDeviceTreeLoaded call reads it again.
+// This is only needed if overlays are loaded or unloaded
+// outside of this package.
+func RefreshDeviceTreeCache() {
+	slotsMutex.Lock()
+	slotsCacheTime = time.Time{}
+	slotsMutex.Unlock()
 }
 
 func IsDeviceTreeLoaded(name string) bool {
-	data, err := dry.FileGetString(ctrlDir + "/slots")
+	slots, err := readSlots()
 	if err != nil {
 		return false
 	}
-	return strings.Contains(data, name)
+	_, ok := findSlot(slots, name)
+	return ok
 }
 
+// FirmwareDir is the directory where the kernel loads
+// device tree overlays from, used by ValidateOverlay.
+var FirmwareDir = "/lib/firmware"
+
+// ValidateOverlay checks without loading it if the device tree overlay name
+// is well-formed, not loaded yet, and if the capemgr will find its file
+// name-VERSION.dtbo (for example BB-ADC-00A0.dtbo) or name.dtbo in FirmwareDir.
+// A version can be appended to name after a colon like "BB-ADC:00A0".
+func ValidateOverlay(name string) error {
+	if name == "" || strings.ContainsAny(name, "/*?[ \t\n") {
+		return fmt.Errorf("invalid device tree overlay name %q", name)
+	}
+	if IsDeviceTreeLoaded(name) {
+		return fmt.Errorf("device tree overlay %s is already loaded", name)
+	}
+	patterns := []string{name + "-*.dtbo", name + ".dtbo"}
+	if colon := strings.IndexByte(name, ':'); colon >= 0 {
+		patterns = []string{name[:colon] + "-" + name[colon+1:] + ".dtbo"}
+	}
+	for _, pattern := range patterns {
+		files, err := filepath.Glob(path.Join(FirmwareDir, pattern))
+		if err != nil {
+			return err
+		}
+		if len(files) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("device tree overlay %s not found in %s", name, FirmwareDir)
+}
+
+// LoadDeviceTree loads the device tree overlay name.
+// The device nodes of the overlay appear asynchronously,
+// use WaitForPath or WaitForPathGlob to wait for them.
 func LoadDeviceTree(name string) error {
 	if IsDeviceTreeLoaded(name) {
 		return nil
 	}
 
-	err := dry.FileSetString(ctrlDir+"/slots", name)
-	if err == nil {
-		time.Sleep(time.Millisecond * 200)
+	defer RefreshDeviceTreeCache()
+	return writeSlots(name)
+}
+
+// LoadDeviceTreeAndVerify loads the device tree overlay name
+// and waits up to DeviceTreeTimeout for every path of expectPaths to appear.
+// The returned error names the first missing path.
+func LoadDeviceTreeAndVerify(name string, expectPaths ...string) error {
+	err := LoadDeviceTree(name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DeviceTreeTimeout)
+	defer cancel()
+	for _, path := range expectPaths {
+		err = WaitForPath(ctx, path)
+		if err != nil {
+			return fmt.Errorf("device tree %s loaded, but %s is missing: %w", name, path, err)
+		}
 	}
-	return err
+	return nil
 }
 
+// UnloadDeviceTree unloads the device tree overlay name.
+// The device nodes of the overlay disappear asynchronously,
+// use UnloadDeviceTreeContext to wait for the overlay to be unloaded.
 func UnloadDeviceTree(name string) error {
-	if !IsDeviceTreeLoaded(name) {
+	// the slot index must not be outdated
+	RefreshDeviceTreeCache()
+	slots, err := readSlots()
+	if err != nil {
+		return err
+	}
+	slot, ok := findSlot(slots, name)
+	if !ok {
 		return nil
 	}
 
-	file, err := os.OpenFile(ctrlDir+"/slots", os.O_RDWR, 0660)
+	defer RefreshDeviceTreeCache()
+	return writeSlots(fmt.Sprintf("-%d", slot.Index))
+}
+
+// UnloadDeviceTreeContext unloads the device tree overlay name
+// and polls the slots file until the overlay is gone or ctx is done,
+// to prevent loading it again before the old one was torn down.
+func UnloadDeviceTreeContext(ctx context.Context, name string) error {
+	err := UnloadDeviceTree(name)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-
-	reader := bufio.NewReader(file)
-	line, err := reader.ReadString('\n')
-	for err != nil {
-		if strings.Contains(line, name) {
-			slot := strings.TrimSpace(line[:strings.IndexRune(line, ':')])
-			_, err = file.WriteString("-" + slot)
-			return err
+	for {
+		RefreshDeviceTreeCache()
+		if !IsDeviceTreeLoaded(name) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for unloading of %s: %w", name, ctx.Err())
+		case <-time.After(waitPollInterval):
 		}
-		line, err = reader.ReadString('\n')
-	}
-	if err != io.EOF {
-		return err
 	}
-	return nil
 }
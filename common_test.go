@@ -0,0 +1,272 @@
+package embedded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// slotsOutput is the slots file of a BeagleBone Black
+// with the eMMC and HDMI capes and an overlay loaded at runtime.
+const slotsOutput = ` 0: 54:PF--- 
+ 1: 55:PF--- 
+ 2: 56:PF--- 
+ 3: 57:PF--- 
+ 4: ff:P-O-L Bone-LT-eMMC-2G,00A0,Texas Instrument,BB-BONE-EMMC-2G
+ 5: ff:P-O-- Bone-Black-HDMI,00A0,Texas Instrument,BB-BONELT-HDMI
+ 6: ff:P-O-- Bone-Black-HDMIN,00A0,Texas Instrument,BB-BONELT-HDMIN
+
+ 7: ff:P-O-L Override Board Name,00A0,Override Manuf,BB-ADC
+`
+
+func TestParseSlots(t *testing.T) {
+	slots, err := ParseSlots(strings.NewReader(slotsOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Slot{
+		{0, "PF---", ""},
+		{1, "PF---", ""},
+		{2, "PF---", ""},
+		{3, "PF---", ""},
+		{4, "P-O-L", "Bone-LT-eMMC-2G,00A0,Texas Instrument,BB-BONE-EMMC-2G"},
+		{5, "P-O--", "Bone-Black-HDMI,00A0,Texas Instrument,BB-BONELT-HDMI"},
+		{6, "P-O--", "Bone-Black-HDMIN,00A0,Texas Instrument,BB-BONELT-HDMIN"},
+		{7, "P-O-L", "Override Board Name,00A0,Override Manuf,BB-ADC"},
+	}
+	if !reflect.DeepEqual(slots, expected) {
+		t.Errorf("ParseSlots = %+v instead of %+v", slots, expected)
+	}
+
+	tests := []struct {
+		name  string
+		index int
+		ok    bool
+	}{
+		{"BB-ADC", 7, true},
+		{"BB-BONELT-HDMI", 5, true},
+		{"BB-BONELT-HDMIN", 6, true},
+		{"BB-PWM0", 0, false},
+		{"PF---", 0, false},
+	}
+	for _, test := range tests {
+		slot, ok := findSlot(slots, test.name)
+		if ok != test.ok || ok && slot.Index != test.index {
+			t.Errorf("findSlot(%q) = %+v, %t", test.name, slot, ok)
+		}
+	}
+}
+
+func TestParseSlotsInvalid(t *testing.T) {
+	for _, content := range []string{"no colon\n", " x: ff:P-O-L BB-ADC\n"} {
+		if slots, err := ParseSlots(strings.NewReader(content)); err == nil {
+			t.Errorf("ParseSlots(%q) = %+v", content, slots)
+		}
+	}
+}
+
+func TestWaitForPath(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "spidev1.0")
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		os.WriteFile(path, nil, 0660)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := WaitForPath(ctx, path); err != nil {
+		t.Errorf("WaitForPath of a delayed node returned %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err := WaitForPath(ctx, filepath.Join(dir, "spidev2.0"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForPath of a missing node returned %v", err)
+	}
+}
+
+func TestWaitForPathGlob(t *testing.T) {
+	dir := t.TempDir()
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		os.Mkdir(filepath.Join(dir, "pwm_test_P9_14.12"), 0770)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	path, err := WaitForPathGlob(ctx, dir, "pwm_test_P9_14")
+	if err != nil || path != filepath.Join(dir, "pwm_test_P9_14.12") {
+		t.Errorf("WaitForPathGlob of a delayed node = %s, %v", path, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	path, err = WaitForPathGlob(ctx, dir, "pwm_test_P9_16")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForPathGlob of a missing node = %s, %v", path, err)
+	}
+}
+
+// fakeCapemgr creates a capemgr directory with a slots file listing slots
+// and replaces the writes to it with an emulation of the capemgr:
+// a written name is appended as new slot and "-index" removes the slot
+// after unloadDelay, or never if unloadDelay is negative.
+func fakeCapemgr(tb testing.TB, slots string, unloadDelay time.Duration) (slotsFile string) {
+	dir := tb.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bone_capemgr.9"), 0770); err != nil {
+		tb.Fatal(err)
+	}
+	slotsFile = filepath.Join(dir, "bone_capemgr.9", "slots")
+	if err := os.WriteFile(slotsFile, []byte(slots), 0660); err != nil {
+		tb.Fatal(err)
+	}
+	savedBase, savedCtrlDir, savedWrite := SysDevicesBase, ctrlDir, writeSlots
+	tb.Cleanup(func() {
+		SysDevicesBase, ctrlDir, writeSlots = savedBase, savedCtrlDir, savedWrite
+		RefreshDeviceTreeCache()
+	})
+	SysDevicesBase = dir
+	if err := Init("bone_capemgr"); err != nil {
+		tb.Fatal(err)
+	}
+
+	var mutex sync.Mutex // serializes the emulated capemgr
+	removeSlot := func(index int) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		data, err := os.ReadFile(slotsFile)
+		if err != nil {
+			return err
+		}
+		prefix := fmt.Sprintf(" %d:", index)
+		var lines []string
+		for _, line := range strings.SplitAfter(string(data), "\n") {
+			if !strings.HasPrefix(line, prefix) {
+				lines = append(lines, line)
+			}
+		}
+		return os.WriteFile(slotsFile, []byte(strings.Join(lines, "")), 0660)
+	}
+	writeSlots = func(command string) error {
+		var index int
+		if _, err := fmt.Sscanf(command, "-%d", &index); err == nil {
+			switch {
+			case unloadDelay == 0:
+				return removeSlot(index)
+			case unloadDelay > 0:
+				time.AfterFunc(unloadDelay, func() { removeSlot(index) })
+			}
+			return nil
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		data, err := os.ReadFile(slotsFile)
+		if err != nil {
+			return err
+		}
+		slots, err := ParseSlots(strings.NewReader(string(data)))
+		if err != nil {
+			return err
+		}
+		line := fmt.Sprintf(" %d: ff:P-O-L Override Board Name,00A0,Override Manuf,%s\n", len(slots), command)
+		return os.WriteFile(slotsFile, append(data, line...), 0660)
+	}
+	return slotsFile
+}
+
+func TestSlotsCacheInvalidation(t *testing.T) {
+	saved := SlotsCacheDuration
+	SlotsCacheDuration = time.Hour
+	defer func() { SlotsCacheDuration = saved }()
+	slotsFile := fakeCapemgr(t, slotsOutput, 0)
+
+	if IsDeviceTreeLoaded("BB-PWM0") {
+		t.Fatal("BB-PWM0 is loaded before loading it")
+	}
+	if err := LoadDeviceTree("BB-PWM0"); err != nil {
+		t.Fatal(err)
+	}
+	if !IsDeviceTreeLoaded("BB-PWM0") {
+		t.Error("the cache was not invalidated by LoadDeviceTree")
+	}
+	if err := UnloadDeviceTree("BB-PWM0"); err != nil {
+		t.Fatal(err)
+	}
+	if IsDeviceTreeLoaded("BB-PWM0") {
+		t.Error("the cache was not invalidated by UnloadDeviceTree")
+	}
+
+	// changes by other processes need RefreshDeviceTreeCache
+	if err := os.WriteFile(slotsFile, []byte(" 0: 54:PF--- \n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if !IsDeviceTreeLoaded("BB-ADC") {
+		t.Error("the cache was not used")
+	}
+	RefreshDeviceTreeCache()
+	if IsDeviceTreeLoaded("BB-ADC") {
+		t.Error("the cache was not invalidated by RefreshDeviceTreeCache")
+	}
+}
+
+// BenchmarkIsDeviceTreeLoaded checks for the overlays of several
+// subsystems like their initialization does, with and without the cache.
+func BenchmarkIsDeviceTreeLoaded(b *testing.B) {
+	fakeCapemgr(b, slotsOutput, 0)
+	saved := SlotsCacheDuration
+	defer func() { SlotsCacheDuration = saved }()
+	overlays := []string{"BB-ADC", "BB-SPIDEV0", "BB-PWM0", "BB-PWM1", "BB-I2C1"}
+
+	for _, duration := range []time.Duration{0, time.Second} {
+		name := "uncached"
+		if duration > 0 {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			SlotsCacheDuration = duration
+			RefreshDeviceTreeCache()
+			for i := 0; i < b.N; i++ {
+				for _, overlay := range overlays {
+					IsDeviceTreeLoaded(overlay)
+				}
+			}
+		})
+	}
+}
+
+func TestUnloadDeviceTreeContext(t *testing.T) {
+	slotsFile := fakeCapemgr(t, slotsOutput, 50*time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := UnloadDeviceTreeContext(ctx, "BB-ADC"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("UnloadDeviceTreeContext returned after %s before the slot was removed", elapsed)
+	}
+	data, err := os.ReadFile(slotsFile)
+	if err != nil || strings.Contains(string(data), "BB-ADC") {
+		t.Errorf("slots file is %q, %v after unloading", data, err)
+	}
+	// unloading an overlay that isn't loaded returns at once
+	if err = UnloadDeviceTreeContext(ctx, "BB-ADC"); err != nil {
+		t.Error(err)
+	}
+
+	// the capemgr never removes the slot
+	fakeCapemgr(t, slotsOutput, -1)
+	ctx, cancel = context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	err = UnloadDeviceTreeContext(ctx, "BB-ADC")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("UnloadDeviceTreeContext of a stuck overlay returned %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package gpio
+
+import (
+	"context"
+	"time"
+)
+
+type ButtonEventType int
+
+const (
+	BUTTON_PRESSED      ButtonEventType = 0
+	BUTTON_RELEASED     ButtonEventType = 1
+	BUTTON_LONG_PRESS   ButtonEventType = 2
+	BUTTON_DOUBLE_CLICK ButtonEventType = 3
+)
+
+type ButtonEvent struct {
+	Time time.Time
+	Type ButtonEventType
+}
+
+// EdgeSource is an input that reports its edges as events,
+// like a GPIO or a gpiotest.MockLine.
+type EdgeSource interface {
+	Value() (Value, error)
+	Events(edge Edge, buffer int) (<-chan EdgeEvent, func())
+}
+
+var _ EdgeSource = &GPIO{}
+
+// Button turns the edges of an input connected to a push button
+// into debounced press, release, long press and double click events.
+// The timing fields can be changed before calling Events.
+type Button struct {
+	input     EdgeSource
+	activeLow bool
+
+	// Debounce is how long the input has to be stable
+	// before a change is accepted.
+	Debounce time.Duration
+	// LongPress is how long the button has to be held down
+	// for a BUTTON_LONG_PRESS event.
+	LongPress time.Duration
+	// DoubleClick is the maximum time between two presses
+	// for a BUTTON_DOUBLE_CLICK event.
+	DoubleClick time.Duration
+}
+
+// NewButton returns a Button for input, usually a GPIO.
+// activeLow has to be true for the common wiring of a button
+// that pulls the input to ground against a pull-up when pressed.
+func NewButton(input EdgeSource, activeLow bool) *Button {
+	return &Button{
+		input:       input,
+		activeLow:   activeLow,
+		Debounce:    20 * time.Millisecond,
+		LongPress:   time.Second,
+		DoubleClick: 400 * time.Millisecond,
+	}
+}
+
+func (button *Button) isPressed(value Value) bool {
+	return (value == HIGH) != button.activeLow
+}
+
+// Events starts a goroutine that detects the edges of the input
+// and sends the button events into the returned channel
+// until ctx is done or an error happens, then the channel is closed.
+// Every press sends BUTTON_PRESSED and every release BUTTON_RELEASED.
+// BUTTON_LONG_PRESS follows BUTTON_PRESSED if the button is still held
+// after LongPress, BUTTON_DOUBLE_CLICK follows the BUTTON_PRESSED
+// of a second press within DoubleClick after the first one.
+func (button *Button) Events(ctx context.Context) (<-chan ButtonEvent, error) {
+	value, err := button.input.Value()
+	if err != nil {
+		return nil, err
+	}
+	edges, cancel := button.input.Events(EDGE_BOTH, 16)
+	events := make(chan ButtonEvent, 16)
+
+	go func() {
+		defer close(events)
+		defer cancel()
+
+		send := func(t ButtonEventType) bool {
+			select {
+			case events <- ButtonEvent{Now(), t}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		pressed := button.isPressed(value)
+		var lastPress time.Time
+		var debounce, longPress <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case _, ok := <-edges:
+				if !ok {
+					return
+				}
+				// every edge restarts the debounce interval
+				debounce = time.After(button.Debounce)
+
+			case <-debounce:
+				debounce = nil
+				value, err := button.input.Value()
+				if err != nil {
+					return
+				}
+				if button.isPressed(value) == pressed {
+					continue
+				}
+				pressed = !pressed
+				if !pressed {
+					longPress = nil
+					if !send(BUTTON_RELEASED) {
+						return
+					}
+					continue
+				}
+				longPress = time.After(button.LongPress)
+				if !send(BUTTON_PRESSED) {
+					return
+				}
+				now := Now()
+				if !lastPress.IsZero() && now.Sub(lastPress) <= button.DoubleClick {
+					lastPress = time.Time{}
+					if !send(BUTTON_DOUBLE_CLICK) {
+						return
+					}
+				} else {
+					lastPress = now
+				}
+
+			case <-longPress:
+				longPress = nil
+				if !send(BUTTON_LONG_PRESS) {
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,96 @@
+package gpio_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SpaceLeap/go-embedded/gpio"
+	"github.com/SpaceLeap/go-embedded/gpio/gpiotest"
+)
+
+// step injects an edge to value and waits before the next step.
+type step struct {
+	value gpio.Value
+	wait  time.Duration
+}
+
+func TestButton(t *testing.T) {
+	const (
+		pressed     = gpio.BUTTON_PRESSED
+		released    = gpio.BUTTON_RELEASED
+		longPress   = gpio.BUTTON_LONG_PRESS
+		doubleClick = gpio.BUTTON_DOUBLE_CLICK
+		settle      = 50 * time.Millisecond
+		bounce      = time.Millisecond
+	)
+	tests := []struct {
+		name      string
+		initial   gpio.Value
+		activeLow bool
+		steps     []step
+		events    []gpio.ButtonEventType
+	}{
+		{"press", gpio.LOW, false,
+			[]step{{gpio.HIGH, settle}},
+			[]gpio.ButtonEventType{pressed}},
+		{"release", gpio.LOW, false,
+			[]step{{gpio.HIGH, settle}, {gpio.LOW, settle}},
+			[]gpio.ButtonEventType{pressed, released}},
+		{"active low", gpio.HIGH, true,
+			[]step{{gpio.LOW, settle}, {gpio.HIGH, settle}},
+			[]gpio.ButtonEventType{pressed, released}},
+		{"long press", gpio.LOW, false,
+			[]step{{gpio.HIGH, 250 * time.Millisecond}, {gpio.LOW, settle}},
+			[]gpio.ButtonEventType{pressed, longPress, released}},
+		{"double click", gpio.LOW, false,
+			[]step{{gpio.HIGH, 30 * time.Millisecond}, {gpio.LOW, 30 * time.Millisecond}, {gpio.HIGH, 30 * time.Millisecond}, {gpio.LOW, settle}},
+			[]gpio.ButtonEventType{pressed, released, pressed, doubleClick, released}},
+		{"slow clicks", gpio.LOW, false,
+			[]step{{gpio.HIGH, 30 * time.Millisecond}, {gpio.LOW, 150 * time.Millisecond}, {gpio.HIGH, 30 * time.Millisecond}, {gpio.LOW, settle}},
+			[]gpio.ButtonEventType{pressed, released, pressed, released}},
+		{"bounce", gpio.LOW, false,
+			[]step{
+				{gpio.HIGH, bounce}, {gpio.LOW, bounce}, {gpio.HIGH, bounce}, {gpio.LOW, bounce}, {gpio.HIGH, settle},
+				{gpio.LOW, bounce}, {gpio.HIGH, bounce}, {gpio.LOW, settle},
+			},
+			[]gpio.ButtonEventType{pressed, released}},
+		{"glitch", gpio.LOW, false,
+			[]step{{gpio.HIGH, bounce}, {gpio.LOW, settle}},
+			nil},
+	}
+	for _, test := range tests {
+		line := gpiotest.NewMockLine(gpio.DIRECTION_IN, test.initial)
+		button := gpio.NewButton(line, test.activeLow)
+		button.Debounce = 10 * time.Millisecond
+		button.LongPress = 150 * time.Millisecond
+		button.DoubleClick = 100 * time.Millisecond
+
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := button.Events(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, step := range test.steps {
+			line.InjectEdge(step.value)
+			time.Sleep(step.wait)
+		}
+		cancel()
+
+		var types []gpio.ButtonEventType
+		for event := range events {
+			types = append(types, event.Type)
+		}
+		if len(types) != len(test.events) {
+			t.Errorf("%s: events %v instead of %v", test.name, types, test.events)
+			continue
+		}
+		for i := range types {
+			if types[i] != test.events[i] {
+				t.Errorf("%s: events %v instead of %v", test.name, types, test.events)
+				break
+			}
+		}
+		line.Close()
+	}
+}
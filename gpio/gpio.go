@@ -1,12 +1,17 @@
 package gpio
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/SpaceLeap/go-embedded"
 	"github.com/ungerik/go-dry"
 )
 
@@ -51,21 +56,72 @@ const (
 	PUD_UP   PullUpDown = 2
 )
 
+type DriveMode int
+
+const (
+	DRIVE_PUSH_PULL   DriveMode = 0
+	DRIVE_OPEN_DRAIN  DriveMode = 1
+	DRIVE_OPEN_SOURCE DriveMode = 2
+)
+
+var classPath = "/sys/class/gpio"
+
+// Now returns the time used for EdgeEvent.Time.
+// It can be replaced by a fake clock for deterministic tests
+// of code depending on edge timing.
+var Now func() time.Time = time.Now
+
+// Init overrides the default sysfs GPIO class path /sys/class/gpio
+// for all GPIOs created afterwards.
+func Init(gpioClassPath string) {
+	classPath = gpioClassPath
+}
+
+func pinPath(nr int, attribute string) string {
+	return fmt.Sprintf("%s/gpio%d/%s", classPath, nr, attribute)
+}
+
 func IsExported(nr int) bool {
-	return dry.FileExists(fmt.Sprintf("/sys/class/gpio/gpio%d/", nr))
+	return dry.FileExists(pinPath(nr, ""))
+}
+
+// Line is the interface of a GPIO line implemented by GPIO.
+// Code depending on Line instead of *GPIO can be tested
+// with gpiotest.MockLine.
+type Line interface {
+	Value() (Value, error)
+	SetValue(value Value) error
+	WaitForEdge(edge Edge) (Value, error)
+	Direction() (Direction, error)
+	SetDirection(direction Direction) error
+	Close() error
 }
 
+var _ Line = &GPIO{}
+
 type GPIO struct {
-	nr        int
-	valueFile *os.File
-	epollFd   dry.SyncInt
-	edge      Edge
+	missedEdges uint64 // first field for 64 bit alignment of atomic access
+	nr          int
+	valueFile   *os.File
+	epollFd     dry.SyncInt
+	edge        Edge
+	edgeValue   Value
+	edgeValueOk bool
+	lastValue   Value
+	lastValueOk bool
+	blocking    bool // open the value file without O_NONBLOCK
+	nonblocking bool // the value file is open with O_NONBLOCK
+	verifyEdge  bool // read the edge back after writing it
 }
 
 // NewGPIO exports the GPIO pin nr.
 func NewGPIO(nr int, direction Direction) (gpio *GPIO, err error) {
 	if !IsExported(nr) {
-		err = dry.FilePrintf("/sys/class/gpio/export", "%d", nr)
+		err = embedded.WriteSysfsInt(classPath+"/export", nr)
+		if err != nil {
+			return nil, err
+		}
+		err = waitForExport(nr)
 		if err != nil {
 			return nil, err
 		}
@@ -81,29 +137,90 @@ func NewGPIO(nr int, direction Direction) (gpio *GPIO, err error) {
 	return gpio, nil
 }
 
+var (
+	// ExportSettleTimeout is how long NewGPIO waits for the
+	// direction and value files of a GPIO to appear after exporting it.
+	ExportSettleTimeout = time.Second
+	// ExportPollInterval is how often NewGPIO checks for the files.
+	ExportPollInterval = 10 * time.Millisecond
+)
+
+func waitForExport(nr int) error {
+	deadline := time.Now().Add(ExportSettleTimeout)
+	for _, attribute := range []string{"direction", "value"} {
+		for !dry.FileExists(pinPath(nr, attribute)) {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("GPIO %d %s file did not appear within %s after export", nr, attribute, ExportSettleTimeout)
+			}
+			time.Sleep(ExportPollInterval)
+		}
+	}
+	return nil
+}
+
 // Close unexports the GPIO pin.
+// It continues after failures and returns all errors joined.
 func (gpio *GPIO) Close() error {
 	gpio.DisableEdgeDetection()
 
+	var errs []error
 	if gpio.valueFile != nil {
-		gpio.valueFile.Close()
+		errs = append(errs, gpio.valueFile.Close())
 	}
 
-	if !IsExported(gpio.nr) {
-		return nil
+	if IsExported(gpio.nr) {
+		errs = append(errs, embedded.WriteSysfsInt(classPath+"/unexport", gpio.nr))
 	}
-	return dry.FilePrintf("/sys/class/gpio/unexport", "%d", gpio.nr)
+	return errors.Join(errs...)
 }
 
 func (gpio *GPIO) Direction() (Direction, error) {
-	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/direction", gpio.nr)
-	direction, err := dry.FileGetString(filename)
+	filename := pinPath(gpio.nr, "direction")
+	direction, err := embedded.ReadSysfsString(filename)
 	return Direction(direction), err
 }
 
+// SetDirection sets the direction of the GPIO.
+// Switching to DIRECTION_OUT disables any active edge detection,
+// so edges configured while the pin was an input don't leak
+// into its use as an output.
 func (gpio *GPIO) SetDirection(direction Direction) error {
-	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/direction", gpio.nr)
-	return dry.FileSetString(filename, string(direction))
+	if direction == DIRECTION_OUT {
+		gpio.DisableEdgeDetection()
+	}
+	gpio.lastValueOk = false
+	filename := pinPath(gpio.nr, "direction")
+	return embedded.WriteSysfsString(filename, string(direction))
+}
+
+// SetDirectionOut switches the GPIO to output with the initial value
+// in a single write of "low" or "high" to the direction file,
+// avoiding a glitch between setting the direction and the value.
+// Like SetDirection it disables any active edge detection.
+func (gpio *GPIO) SetDirectionOut(initial Value) error {
+	direction := "low"
+	if initial != LOW {
+		direction = "high"
+	}
+	gpio.DisableEdgeDetection()
+	gpio.lastValueOk = false
+	return embedded.WriteSysfsString(pinPath(gpio.nr, "direction"), direction)
+}
+
+// Healthy checks if the direction of the GPIO can be read.
+func (gpio *GPIO) Healthy() error {
+	_, err := gpio.Direction()
+	return err
+}
+
+// SetDrive sets the output drive mode.
+// The sysfs GPIO interface only supports push-pull outputs,
+// DRIVE_OPEN_DRAIN and DRIVE_OPEN_SOURCE return an error.
+func (gpio *GPIO) SetDrive(drive DriveMode) error {
+	if drive != DRIVE_PUSH_PULL {
+		return fmt.Errorf("GPIO %d drive mode %d not supported by sysfs GPIO", gpio.nr, drive)
+	}
+	return nil
 }
 
 // func (gpio *GPIO) SetPullUpDown(pull PullUpDown) error {
@@ -116,59 +233,281 @@ func (gpio *GPIO) SetDirection(direction Direction) error {
 // 	return err
 // }
 
+// SetBlocking selects if the value file is opened without O_NONBLOCK
+// for GPIOs that are only polled with Value, which avoids the transient
+// empty reads of some kernels. The default is non-blocking.
+// Edge detection always needs a non-blocking value file,
+// so WaitForEdge re-opens a blocking one non-blocking.
+// After DisableEdgeDetection, SetBlocking(true) has to be called again
+// to get back a blocking value file.
+func (gpio *GPIO) SetBlocking(blocking bool) {
+	gpio.blocking = blocking
+	if blocking && gpio.valueFile != nil && gpio.nonblocking && !gpio.IsEdgeDetectionEnabled() {
+		gpio.valueFile.Close()
+		gpio.valueFile = nil
+	}
+}
+
 func (gpio *GPIO) ensureValueFileIsOpen() error {
+	return gpio.openValueFile(!gpio.blocking)
+}
+
+// openValueFile opens the value file if it is not open yet,
+// or re-opens it if it is blocking but nonblocking is requested.
+func (gpio *GPIO) openValueFile(nonblocking bool) error {
 	if gpio.valueFile != nil {
-		return nil
+		if gpio.nonblocking || !nonblocking {
+			return nil
+		}
+		gpio.valueFile.Close()
+		gpio.valueFile = nil
+	}
+	flags := os.O_RDWR
+	if nonblocking {
+		flags |= syscall.O_NONBLOCK
 	}
-	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/value", gpio.nr)
-	file, err := os.OpenFile(filename, os.O_RDWR|syscall.O_NONBLOCK, 0660)
+	filename := pinPath(gpio.nr, "value")
+	file, err := os.OpenFile(filename, flags, 0660)
 	if err == nil {
 		gpio.valueFile = file
+		gpio.nonblocking = nonblocking
 	}
 	return err
 }
 
+// readValueAt reads the value file from the start.
+// It is a variable so that tests can simulate kernel quirks.
+var readValueAt = func(file *os.File, buf []byte) (int, error) {
+	return file.ReadAt(buf, 0)
+}
+
 func (gpio *GPIO) Value() (Value, error) {
 	if err := gpio.ensureValueFileIsOpen(); err != nil {
 		return 0, err
 	}
 	val := make([]byte, 1)
-	_, err := gpio.valueFile.ReadAt(val, 0)
-	if err != nil {
+	n, err := readValueAt(gpio.valueFile, val)
+	if n == 0 && (err == nil || err == io.EOF) {
+		// Some kernels return no data right after a direction change,
+		// so try once more
+		n, err = readValueAt(gpio.valueFile, val)
+	}
+	if n == 0 {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("GPIO %d value file returned no data", gpio.nr)
+		}
 		return 0, err
 	}
+	if val[0] != '0' && val[0] != '1' {
+		return 0, fmt.Errorf("GPIO %d invalid value %q", gpio.nr, val[0])
+	}
 	return Value(val[0] - '0'), nil
 }
 
+// ReadMany reads the values of pins with one pread syscall per pin
+// and without the retry and allocation of Value, to reduce the overhead
+// of reading a group of pins with the sysfs interface.
+// The pins are read one after the other, so the values
+// are not sampled at the same time.
+func ReadMany(pins []*GPIO) ([]Value, error) {
+	values := make([]Value, len(pins))
+	var buf [1]byte
+	for i, gpio := range pins {
+		if err := gpio.ensureValueFileIsOpen(); err != nil {
+			return nil, err
+		}
+		n, err := syscall.Pread(int(gpio.valueFile.Fd()), buf[:], 0)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 || buf[0] != '0' && buf[0] != '1' {
+			return nil, fmt.Errorf("GPIO %d invalid value %q", gpio.nr, buf[:n])
+		}
+		values[i] = Value(buf[0] - '0')
+	}
+	return values, nil
+}
+
+// IsHigh returns if Value is HIGH.
+// The value is the logical value of the kernel, so a pin
+// with the sysfs active_low attribute set reads HIGH
+// when the physical level is low.
+func (gpio *GPIO) IsHigh() (bool, error) {
+	value, err := gpio.Value()
+	if err != nil {
+		return false, err
+	}
+	return value == HIGH, nil
+}
+
+// IsLow returns if Value is LOW, see IsHigh for active-low pins.
+func (gpio *GPIO) IsLow() (bool, error) {
+	high, err := gpio.IsHigh()
+	if err != nil {
+		return false, err
+	}
+	return !high, nil
+}
+
+// SetValue writes value with a single pwrite syscall at offset 0.
 func (gpio *GPIO) SetValue(value Value) (err error) {
 	if err = gpio.ensureValueFileIsOpen(); err != nil {
 		return err
 	}
-	gpio.valueFile.Seek(0, os.SEEK_SET)
-	_, err = gpio.valueFile.Write([]byte{'0' + byte(value)})
+	_, err = gpio.valueFile.WriteAt([]byte{'0' + byte(value)}, 0)
+	gpio.lastValue = value
+	gpio.lastValueOk = err == nil
 	return err
 }
 
+// SetValueCached works like SetValue, but skips the write
+// if value equals the last value written with SetValue or SetValueCached.
+// Use SetValue if the write itself matters.
+func (gpio *GPIO) SetValueCached(value Value) error {
+	if gpio.lastValueOk && value == gpio.lastValue {
+		return nil
+	}
+	return gpio.SetValue(value)
+}
+
+// Pulse sets the GPIO to value for width and then to the opposite value,
+// for example to trigger a HC-SR04 ultrasonic sensor.
+// The width is timed by busy waiting on a locked OS thread
+// which is more precise than time.Sleep for widths below a millisecond.
+// On a kernel without realtime scheduling the thread can still
+// be preempted, so the actual pulse may be longer than width.
+func (gpio *GPIO) Pulse(value Value, width time.Duration) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	err := gpio.SetValue(value)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	for time.Since(start) < width {
+	}
+	if value == LOW {
+		return gpio.SetValue(HIGH)
+	}
+	return gpio.SetValue(LOW)
+}
+
+// MeasurePulses records the durations of the next count levels of an input,
+// each measured from one level transition to the next, starting
+// with the first transition after the call, as needed for single pin
+// protocols like the one of DHT11/DHT22 humidity sensors.
+// The value is polled in a busy loop on a locked OS thread.
+// If timeout elapses before count levels were measured,
+// the durations measured so far are returned with an error.
+//
+// Every poll is a read syscall of the sysfs value file, so the resolution
+// is in the order of microseconds. On a kernel without realtime scheduling
+// the thread can be preempted, which makes single levels longer
+// and merges short pulses, so callers should validate the result.
+func (gpio *GPIO) MeasurePulses(count int, timeout time.Duration) ([]time.Duration, error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	durations := make([]time.Duration, 0, count)
+	start := time.Now()
+	level, err := gpio.Value()
+	if err != nil {
+		return nil, err
+	}
+	var last time.Time // zero until the first transition
+	for len(durations) < count {
+		value, err := gpio.Value()
+		if err != nil {
+			return durations, err
+		}
+		now := time.Now()
+		if value != level {
+			if !last.IsZero() {
+				durations = append(durations, now.Sub(last))
+			}
+			last = now
+			level = value
+		}
+		if now.Sub(start) > timeout {
+			return durations, fmt.Errorf("GPIO %d measured %d of %d pulses within %s", gpio.nr, len(durations), count, timeout)
+		}
+	}
+	return durations, nil
+}
+
+// Edge reads the edge detection configuration from the kernel,
+// which may have been set by a previous process.
+func (gpio *GPIO) Edge() (Edge, error) {
+	edge, err := embedded.ReadSysfsString(pinPath(gpio.nr, "edge"))
+	return Edge(edge), err
+}
+
+// SetVerifyEdge sets if the edge detection configuration is read back
+// with Edge after writing it, to return an error if the kernel
+// did not accept it. The default is false.
+func (gpio *GPIO) SetVerifyEdge(verify bool) {
+	gpio.verifyEdge = verify
+}
+
 func (gpio *GPIO) setEdge(edge Edge) error {
 	if edge == gpio.edge {
 		return nil
 	}
-	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/edge", gpio.nr)
-	err := dry.FileSetString(filename, string(edge))
-	if err == nil {
-		gpio.edge = edge
+	filename := pinPath(gpio.nr, "edge")
+	err := embedded.WriteSysfsString(filename, string(edge))
+	if err != nil {
+		return err
 	}
-	return err
+	if gpio.verifyEdge {
+		readBack, err := gpio.Edge()
+		if err != nil {
+			return err
+		}
+		if readBack != edge {
+			return fmt.Errorf("GPIO %d edge is %q after writing %q", gpio.nr, readBack, edge)
+		}
+	}
+	gpio.edge = edge
+	gpio.edgeValueOk = false
+	return nil
 }
 
 var dummyEpollEvents = make([]syscall.EpollEvent, 1)
 
+// WaitForEdge blocks until the next edge and returns the value read after it.
+//
+// The sysfs interface is edge triggered and does not queue events,
+// so edges following each other faster than the waiting thread wakes up
+// are collapsed into one and the returned value is the final level.
+// For EDGE_BOTH this is detected on a best effort basis when the same value
+// is read twice in a row, see MissedEdges.
 func (gpio *GPIO) WaitForEdge(edge Edge) (value Value, err error) {
+	event, err := gpio.WaitForEdgeEvent(edge)
+	return event.Value, err
+}
+
+// WaitForEdgeEvent works like WaitForEdge, but also returns
+// the time of the edge from Now, taken right after the wake up
+// by the edge, before the value is read.
+func (gpio *GPIO) WaitForEdgeEvent(edge Edge) (EdgeEvent, error) {
+	for {
+		event, ok, err := gpio.waitForEdge(edge, -1)
+		if err != nil || ok {
+			return event, err
+		}
+	}
+}
+
+// waitForEdge works like WaitForEdgeEvent, but returns with ok false
+// if no edge happened within timeoutMsec milliseconds.
+// A timeoutMsec of -1 waits forever.
+func (gpio *GPIO) waitForEdge(edge Edge, timeoutMsec int) (event EdgeEvent, ok bool, err error) {
 	if err = gpio.setEdge(edge); err != nil {
-		return 0, err
+		return EdgeEvent{}, false, err
 	}
-	if err = gpio.ensureValueFileIsOpen(); err != nil {
-		return 0, err
+	if err = gpio.openValueFile(true); err != nil {
+		return EdgeEvent{}, false, err
 	}
 
 	epollFd := gpio.epollFd.Get()
@@ -176,7 +515,7 @@ func (gpio *GPIO) WaitForEdge(edge Edge) (value Value, err error) {
 	if epollFd == 0 {
 		epollFd, err = syscall.EpollCreate(1)
 		if err != nil {
-			return 0, err
+			return EdgeEvent{}, false, err
 		}
 
 		event := &syscall.EpollEvent{
@@ -186,24 +525,55 @@ func (gpio *GPIO) WaitForEdge(edge Edge) (value Value, err error) {
 		err = syscall.EpollCtl(epollFd, syscall.EPOLL_CTL_ADD, int(gpio.valueFile.Fd()), event)
 		if err != nil {
 			syscall.Close(epollFd)
-			return 0, err
+			return EdgeEvent{}, false, err
 		}
 
 		// first time triggers with current state, so ignore
-		_, err = syscall.EpollWait(epollFd, dummyEpollEvents, -1)
+		_, err = epollWait(epollFd, -1)
 		if err != nil {
 			syscall.Close(epollFd)
-			return 0, err
+			return EdgeEvent{}, false, err
 		}
 
 		gpio.epollFd.Set(epollFd)
 	}
 
-	_, err = syscall.EpollWait(epollFd, dummyEpollEvents, -1)
+	n, err := epollWait(epollFd, timeoutMsec)
+	if err != nil || n == 0 {
+		return EdgeEvent{}, false, err
+	}
+	now := Now()
+	value, err := gpio.Value()
 	if err != nil {
-		return 0, err
+		return EdgeEvent{}, false, err
+	}
+	if edge == EDGE_BOTH {
+		// Two edges of the same direction in a row mean that
+		// at least one pair of edges in between was lost
+		if gpio.edgeValueOk && value == gpio.edgeValue {
+			atomic.AddUint64(&gpio.missedEdges, 2)
+		}
+		gpio.edgeValue = value
+		gpio.edgeValueOk = true
+	}
+	return EdgeEvent{now, value}, true, nil
+}
+
+// epollWait calls syscall.EpollWait and retries it
+// when interrupted by a signal.
+func epollWait(epollFd, timeoutMsec int) (n int, err error) {
+	for {
+		n, err = syscall.EpollWait(epollFd, dummyEpollEvents, timeoutMsec)
+		if err != syscall.EINTR {
+			return n, err
+		}
 	}
-	return gpio.Value()
+}
+
+// MissedEdges returns the number of edges that WaitForEdge detected
+// as lost with EDGE_BOTH. This is a lower bound, not an exact count.
+func (gpio *GPIO) MissedEdges() uint64 {
+	return atomic.LoadUint64(&gpio.missedEdges)
 }
 
 func (gpio *GPIO) IsEdgeDetectionEnabled() bool {
@@ -235,11 +605,58 @@ func (gpio *GPIO) StartEdgeDetectCallbacks(edge Edge, callback func(Value)) {
 }
 
 // StartEdgeDetectEvents starts a thread that sends EdgeEvent instances into
-// the events channel for every edge. EdgeEvent contains the time of the event,
-// to be also useful for buffered channels where the events are read later.
+// the events channel for every edge. EdgeEvent contains the time of the event
+// from Now, to be also useful for buffered channels where the events are read later.
 // An error or DisableEdgeDetection stops the thread.
 func (gpio *GPIO) StartEdgeDetectEvents(edge Edge, events chan EdgeEvent) {
 	gpio.StartEdgeDetectCallbacks(edge, func(value Value) {
-		events <- EdgeEvent{time.Now(), value}
+		events <- EdgeEvent{Now(), value}
 	})
 }
+
+// eventsPollMsec is how often the goroutine of Events
+// checks if it was cancelled while waiting for edges.
+const eventsPollMsec = 100
+
+// Events sets the edge and starts a goroutine that sends an EdgeEvent
+// for every edge into the returned channel with the given buffer size.
+// The returned cancel function stops the goroutine, waits for it
+// to return and closes the channel. Cancelling takes up to 100ms.
+// An error also stops the goroutine and closes the channel.
+// Edge detection stays enabled after cancel, see DisableEdgeDetection.
+func (gpio *GPIO) Events(edge Edge, buffer int) (<-chan EdgeEvent, func()) {
+	events := make(chan EdgeEvent, buffer)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(events)
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			event, ok, err := gpio.waitForEdge(edge, eventsPollMsec)
+			if err != nil {
+				return
+			}
+			if !ok {
+				continue
+			}
+			select {
+			case events <- event:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	var stopOnce sync.Once
+	cancel := func() {
+		stopOnce.Do(func() { close(stop) })
+		<-done
+	}
+	return events, cancel
+}
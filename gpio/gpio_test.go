@@ -0,0 +1,401 @@
+package gpio
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeClassPath replaces the sysfs GPIO class path
+// with a temporary directory until the end of the test.
+func fakeClassPath(tb testing.TB) string {
+	dir := tb.TempDir()
+	saved := classPath
+	classPath = dir
+	tb.Cleanup(func() { classPath = saved })
+	return dir
+}
+
+// fakePin creates the attribute files of GPIO nr in the class path
+// set by fakeClassPath and returns a GPIO using them.
+func fakePin(tb testing.TB, nr int, value string) *GPIO {
+	attributes := map[string]string{
+		"direction": "in\n",
+		"edge":      "none\n",
+		"value":     value,
+	}
+	for attribute, content := range attributes {
+		path := pinPath(nr, attribute)
+		if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0660); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	gpio := &GPIO{nr: nr}
+	tb.Cleanup(func() {
+		if gpio.valueFile != nil {
+			gpio.valueFile.Close()
+		}
+	})
+	return gpio
+}
+
+func TestValue(t *testing.T) {
+	fakeClassPath(t)
+	gpio := fakePin(t, 5, "1\n")
+	value, err := gpio.Value()
+	if err != nil || value != HIGH {
+		t.Errorf("Value = %d, %v", value, err)
+	}
+}
+
+func TestValueReads(t *testing.T) {
+	tests := []struct {
+		name  string
+		reads []string // data returned by the reads, "" for an empty read
+		value Value
+		ok    bool
+	}{
+		{"empty once", []string{"", "1"}, HIGH, true},
+		{"empty twice", []string{"", ""}, 0, false},
+		{"invalid", []string{"x"}, 0, false},
+		{"low", []string{"0"}, LOW, true},
+	}
+	fakeClassPath(t)
+	saved := readValueAt
+	defer func() { readValueAt = saved }()
+
+	for _, test := range tests {
+		reads := test.reads
+		readValueAt = func(file *os.File, buf []byte) (int, error) {
+			if len(reads) == 0 {
+				t.Fatalf("%s: too many reads", test.name)
+			}
+			data := reads[0]
+			reads = reads[1:]
+			if data == "" {
+				return 0, io.EOF
+			}
+			return copy(buf, data), nil
+		}
+		gpio := fakePin(t, 5, "0\n")
+		value, err := gpio.Value()
+		if (err == nil) != test.ok || value != test.value {
+			t.Errorf("%s: Value = %d, %v", test.name, value, err)
+		}
+		if len(reads) > 0 {
+			t.Errorf("%s: %d reads left", test.name, len(reads))
+		}
+	}
+}
+
+func TestReadMany(t *testing.T) {
+	fakeClassPath(t)
+	pins := []*GPIO{fakePin(t, 5, "0\n"), fakePin(t, 6, "1\n"), fakePin(t, 7, "1\n")}
+	values, err := ReadMany(pins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Value{LOW, HIGH, HIGH}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("ReadMany = %v instead of %v", values, expected)
+			break
+		}
+	}
+
+	for _, content := range []string{"x\n", ""} {
+		pins = []*GPIO{fakePin(t, 5, "0\n"), fakePin(t, 6, content)}
+		if values, err = ReadMany(pins); err == nil {
+			t.Errorf("ReadMany = %v with value file %q", values, content)
+		}
+	}
+}
+
+func TestWaitForExport(t *testing.T) {
+	dir := fakeClassPath(t)
+	savedTimeout, savedInterval := ExportSettleTimeout, ExportPollInterval
+	ExportSettleTimeout, ExportPollInterval = 200*time.Millisecond, time.Millisecond
+	t.Cleanup(func() { ExportSettleTimeout, ExportPollInterval = savedTimeout, savedInterval })
+
+	// udev creates the files some time after the export
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(20 * time.Millisecond)
+		for _, attribute := range []string{"direction", "value"} {
+			path := pinPath(5, attribute)
+			if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := os.WriteFile(path, []byte("0\n"), 0660); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	if err := waitForExport(5); err != nil {
+		t.Errorf("waitForExport of delayed files returned %v", err)
+	}
+	<-done
+
+	if err := waitForExport(6); err == nil || !strings.Contains(err.Error(), "direction") {
+		t.Errorf("waitForExport of missing files returned %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "gpio7"), 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(pinPath(7, "direction"), []byte("in\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitForExport(7); err == nil || !strings.Contains(err.Error(), "value") {
+		t.Errorf("waitForExport of a missing value file returned %v", err)
+	}
+}
+
+func TestEdge(t *testing.T) {
+	fakeClassPath(t)
+	gpio := fakePin(t, 5, "0\n")
+	if edge, err := gpio.Edge(); err != nil || edge != EDGE_NONE {
+		t.Errorf("Edge = %q, %v instead of none", edge, err)
+	}
+	// a previous process configured the edge
+	if err := os.WriteFile(pinPath(5, "edge"), []byte("both\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if edge, err := gpio.Edge(); err != nil || edge != EDGE_BOTH {
+		t.Errorf("Edge = %q, %v instead of both", edge, err)
+	}
+	if _, err := (&GPIO{nr: 6}).Edge(); err == nil {
+		t.Error("Edge of a missing edge file returned no error")
+	}
+}
+
+func TestSetEdgeVerify(t *testing.T) {
+	fakeClassPath(t)
+	gpio := fakePin(t, 5, "0\n")
+	gpio.SetVerifyEdge(true)
+	if err := gpio.setEdge(EDGE_RISING); err != nil {
+		t.Fatal(err)
+	}
+	if gpio.edge != EDGE_RISING {
+		t.Errorf("cached edge is %q", gpio.edge)
+	}
+
+	// an edge file that drops writes like a GPIO without interrupt
+	gpio = fakePin(t, 6, "0\n")
+	edgePath := pinPath(6, "edge")
+	if err := os.Remove(edgePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(os.DevNull, edgePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := gpio.setEdge(EDGE_FALLING); err != nil {
+		t.Errorf("setEdge without verify returned %v", err)
+	}
+	gpio = &GPIO{nr: 6}
+	gpio.SetVerifyEdge(true)
+	if err := gpio.setEdge(EDGE_FALLING); err == nil {
+		t.Error("setEdge with verify accepted a dropped write")
+	}
+	if gpio.edge != "" {
+		t.Errorf("cached edge is %q after the failed verify", gpio.edge)
+	}
+}
+
+func TestSetDirectionOut(t *testing.T) {
+	fakeClassPath(t)
+	tests := []struct {
+		initial   Value
+		direction string
+	}{
+		{LOW, "low"},
+		{HIGH, "high"},
+	}
+	for _, test := range tests {
+		gpio := fakePin(t, 5, "0\n")
+		if err := gpio.SetDirectionOut(test.initial); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(pinPath(5, "direction"))
+		if err != nil || string(data) != test.direction {
+			t.Errorf("SetDirectionOut(%d) wrote %q, %v instead of %q", test.initial, data, err, test.direction)
+		}
+	}
+}
+
+func TestIsHighIsLow(t *testing.T) {
+	fakeClassPath(t)
+	tests := []struct {
+		activeLow string
+		physical  Value
+		high      bool
+	}{
+		{"0", LOW, false},
+		{"0", HIGH, true},
+		{"1", LOW, true},
+		{"1", HIGH, false},
+	}
+	for _, test := range tests {
+		// the kernel inverts the value of active-low pins
+		value := test.physical
+		if test.activeLow == "1" {
+			value ^= 1
+		}
+		gpio := fakePin(t, 5, string('0'+byte(value))+"\n")
+		if err := os.WriteFile(pinPath(5, "active_low"), []byte(test.activeLow+"\n"), 0660); err != nil {
+			t.Fatal(err)
+		}
+		high, err := gpio.IsHigh()
+		if err != nil || high != test.high {
+			t.Errorf("active_low %s, level %d: IsHigh = %t, %v", test.activeLow, test.physical, high, err)
+		}
+		low, err := gpio.IsLow()
+		if err != nil || low == test.high {
+			t.Errorf("active_low %s, level %d: IsLow = %t, %v", test.activeLow, test.physical, low, err)
+		}
+	}
+}
+
+func TestSwitchToOutputDisablesEdgeDetection(t *testing.T) {
+	fakeClassPath(t)
+	switches := map[string]func(gpio *GPIO) error{
+		"SetDirection":    func(gpio *GPIO) error { return gpio.SetDirection(DIRECTION_OUT) },
+		"SetDirectionOut": func(gpio *GPIO) error { return gpio.SetDirectionOut(HIGH) },
+	}
+	for name, switchToOutput := range switches {
+		gpio := fakePin(t, 5, "0\n")
+		if err := gpio.setEdge(EDGE_BOTH); err != nil {
+			t.Fatal(err)
+		}
+		if err := gpio.openValueFile(true); err != nil {
+			t.Fatal(err)
+		}
+		// epoll can't watch a regular file, so arm an empty epoll instance
+		epollFd, err := syscall.EpollCreate1(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gpio.epollFd.Set(epollFd)
+
+		if err = switchToOutput(gpio); err != nil {
+			t.Fatal(err)
+		}
+		if gpio.IsEdgeDetectionEnabled() {
+			t.Errorf("%s: edge detection still enabled", name)
+		}
+		_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(epollFd), syscall.F_GETFD, 0)
+		if errno != syscall.EBADF {
+			syscall.Close(epollFd)
+			t.Errorf("%s: epoll fd was not closed", name)
+		}
+		if gpio.edge != EDGE_NONE {
+			t.Errorf("%s: edge is %s", name, gpio.edge)
+		}
+		if edge, err := gpio.Edge(); err != nil || edge != EDGE_NONE {
+			t.Errorf("%s: edge file is %q, %v", name, edge, err)
+		}
+	}
+}
+
+func BenchmarkSetValue(b *testing.B) {
+	fakeClassPath(b)
+	gpio := fakePin(b, 5, "0\n")
+	for i := 0; i < b.N; i++ {
+		if err := gpio.SetValue(Value(i & 1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSetValueSeekWrite is the Seek and Write of one byte
+// that SetValue replaced with a single pwrite.
+func BenchmarkSetValueSeekWrite(b *testing.B) {
+	fakeClassPath(b)
+	gpio := fakePin(b, 5, "0\n")
+	if err := gpio.ensureValueFileIsOpen(); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		if _, err := gpio.valueFile.Seek(0, io.SeekStart); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := gpio.valueFile.Write([]byte{'0' + byte(i&1)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSetValueSteady writes the same value in a loop,
+// compare with BenchmarkSetValueCachedSteady.
+func BenchmarkSetValueSteady(b *testing.B) {
+	fakeClassPath(b)
+	gpio := fakePin(b, 5, "0\n")
+	for i := 0; i < b.N; i++ {
+		if err := gpio.SetValue(HIGH); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSetValueCachedSteady writes the same value in a loop,
+// only the first call makes a write syscall.
+func BenchmarkSetValueCachedSteady(b *testing.B) {
+	fakeClassPath(b)
+	gpio := fakePin(b, 5, "0\n")
+	for i := 0; i < b.N; i++ {
+		if err := gpio.SetValueCached(HIGH); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkPins returns 8 pins with open value files.
+func benchmarkPins(b *testing.B) []*GPIO {
+	fakeClassPath(b)
+	pins := make([]*GPIO, 8)
+	for i := range pins {
+		pins[i] = fakePin(b, i, "1\n")
+		if _, err := pins[i].Value(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return pins
+}
+
+func BenchmarkReadMany(b *testing.B) {
+	pins := benchmarkPins(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadMany(pins); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadManyValue is the loop over Value replaced by ReadMany.
+func BenchmarkReadManyValue(b *testing.B) {
+	pins := benchmarkPins(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		values := make([]Value, len(pins))
+		for j, gpio := range pins {
+			value, err := gpio.Value()
+			if err != nil {
+				b.Fatal(err)
+			}
+			values[j] = value
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Package gpiotest provides a mock implementation of gpio.Line
+// for testing code that uses GPIOs without hardware.
+package gpiotest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/SpaceLeap/go-embedded/gpio"
+)
+
+var ErrClosed = errors.New("gpiotest: MockLine is closed")
+
+// MockLine implements gpio.Line in memory.
+// It records all values written with SetValue
+// and lets tests inject values and edges.
+type MockLine struct {
+	mutex     sync.Mutex
+	value     gpio.Value
+	direction gpio.Direction
+	writes    []gpio.Value
+	edges     chan gpio.Value
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var (
+	_ gpio.Line       = &MockLine{}
+	_ gpio.EdgeSource = &MockLine{}
+)
+
+func NewMockLine(direction gpio.Direction, value gpio.Value) *MockLine {
+	return &MockLine{
+		value:     value,
+		direction: direction,
+		edges:     make(chan gpio.Value, 64),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (line *MockLine) Value() (gpio.Value, error) {
+	line.mutex.Lock()
+	defer line.mutex.Unlock()
+	return line.value, nil
+}
+
+func (line *MockLine) SetValue(value gpio.Value) error {
+	line.mutex.Lock()
+	defer line.mutex.Unlock()
+	line.value = value
+	line.writes = append(line.writes, value)
+	return nil
+}
+
+// Writes returns all values written with SetValue.
+func (line *MockLine) Writes() []gpio.Value {
+	line.mutex.Lock()
+	defer line.mutex.Unlock()
+	return append([]gpio.Value(nil), line.writes...)
+}
+
+// SetInput sets the value returned by Value without triggering an edge.
+func (line *MockLine) SetInput(value gpio.Value) {
+	line.mutex.Lock()
+	defer line.mutex.Unlock()
+	line.value = value
+}
+
+// InjectEdge changes the value and triggers an edge
+// for a current or future WaitForEdge call.
+func (line *MockLine) InjectEdge(value gpio.Value) {
+	line.SetInput(value)
+	line.edges <- value
+}
+
+func matchesEdge(edge gpio.Edge, value gpio.Value) bool {
+	return edge == gpio.EDGE_BOTH ||
+		edge == gpio.EDGE_RISING && value == gpio.HIGH ||
+		edge == gpio.EDGE_FALLING && value == gpio.LOW
+}
+
+// WaitForEdge blocks until an edge matching edge is injected
+// with InjectEdge, or the MockLine is closed.
+func (line *MockLine) WaitForEdge(edge gpio.Edge) (gpio.Value, error) {
+	for {
+		select {
+		case value := <-line.edges:
+			if matchesEdge(edge, value) {
+				return value, nil
+			}
+		case <-line.closed:
+			return 0, ErrClosed
+		}
+	}
+}
+
+// Events works like gpio.GPIO.Events: it starts a goroutine
+// that sends an EdgeEvent with the time from gpio.Now for every
+// injected edge matching edge, until the returned cancel function
+// is called or the MockLine is closed.
+// Injected edges are consumed either by Events or by WaitForEdge,
+// so they must not be used at the same time.
+func (line *MockLine) Events(edge gpio.Edge, buffer int) (<-chan gpio.EdgeEvent, func()) {
+	events := make(chan gpio.EdgeEvent, buffer)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(events)
+		for {
+			select {
+			case value := <-line.edges:
+				if !matchesEdge(edge, value) {
+					continue
+				}
+				select {
+				case events <- gpio.EdgeEvent{Time: gpio.Now(), Value: value}:
+				case <-stop:
+					return
+				case <-line.closed:
+					return
+				}
+			case <-stop:
+				return
+			case <-line.closed:
+				return
+			}
+		}
+	}()
+	var stopOnce sync.Once
+	cancel := func() {
+		stopOnce.Do(func() { close(stop) })
+		<-done
+	}
+	return events, cancel
+}
+
+func (line *MockLine) Direction() (gpio.Direction, error) {
+	line.mutex.Lock()
+	defer line.mutex.Unlock()
+	return line.direction, nil
+}
+
+func (line *MockLine) SetDirection(direction gpio.Direction) error {
+	line.mutex.Lock()
+	defer line.mutex.Unlock()
+	line.direction = direction
+	return nil
+}
+
+// Close stops all waiting WaitForEdge calls and Events goroutines.
+func (line *MockLine) Close() error {
+	line.closeOnce.Do(func() { close(line.closed) })
+	return nil
+}
@@ -0,0 +1,21 @@
+package embedded
+
+import "errors"
+
+// Healthchecker is implemented by the devices of the sub-packages
+// to check cheaply and without side effects if a device is still responsive.
+type Healthchecker interface {
+	Healthy() error
+}
+
+// CheckAll calls Healthy of all checkers and returns
+// the joined errors of the unhealthy ones, or nil if all are healthy.
+func CheckAll(checkers ...Healthchecker) error {
+	var errs []error
+	for _, checker := range checkers {
+		if err := checker.Healthy(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,39 @@
+package i2c
+
+// CRC8 calculates the CRC-8 of data with the generator polynomial poly
+// (without the implicit x^8 term) and the initial value init,
+// MSB first, without reflection and final XOR.
+func CRC8(data []byte, poly, init uint8) uint8 {
+	crc := init
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ poly
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+const (
+	// SensirionCRC8Poly is the CRC-8 polynomial x^8 + x^5 + x^4 + 1
+	// used by Sensirion sensors like SHT3x and SCD30.
+	SensirionCRC8Poly = 0x31
+	// SensirionCRC8Init is the initial CRC value used by Sensirion sensors.
+	SensirionCRC8Init = 0xFF
+)
+
+// SMBusPEC calculates the SMBus Packet Error Code, a CRC-8 with the
+// polynomial x^8 + x^2 + x + 1 (0x07) and initial value 0, over the
+// address byte addr<<1 with the R/W bit cleared followed by data.
+// data has to contain every further byte on the bus up to the PEC,
+// including the address byte with the R/W bit set after a repeated start.
+// For a block read from a register that is:
+//
+//	addr<<1, register, addr<<1|1, count, block...
+func SMBusPEC(addr uint8, data []byte) uint8 {
+	return CRC8(data, 0x07, CRC8([]byte{addr << 1}, 0x07, 0))
+}
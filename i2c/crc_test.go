@@ -0,0 +1,79 @@
+package i2c
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestCRC8(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		poly uint8
+		init uint8
+		crc  uint8
+	}{
+		{"CRC-8/SMBUS check", []byte("123456789"), 0x07, 0x00, 0xF4},
+		{"Sensirion SHT3x", []byte{0xBE, 0xEF}, SensirionCRC8Poly, SensirionCRC8Init, 0x92},
+		{"empty", nil, 0x07, 0x00, 0x00},
+	}
+	for _, test := range tests {
+		if crc := CRC8(test.data, test.poly, test.init); crc != test.crc {
+			t.Errorf("%s: CRC8 = 0x%02X instead of 0x%02X", test.name, crc, test.crc)
+		}
+	}
+}
+
+func TestSMBusPEC(t *testing.T) {
+	// read word example of the MLX90614 datasheet:
+	// 0xB4 0x07 0xB5 0xD2 0x3A with PEC 0x30
+	if pec := SMBusPEC(0x5A, []byte{0x07, 0xB5, 0xD2, 0x3A}); pec != 0x30 {
+		t.Errorf("SMBusPEC = 0x%02X instead of 0x30", pec)
+	}
+}
+
+// fakeRDWR replaces the I2C_RDWR ioctl with f until the end of the test.
+func fakeRDWR(t *testing.T, f func(msgs []i2c_msg) error) {
+	saved := rdwrIoctl
+	rdwrIoctl = func(fd uintptr, msgs []i2c_msg) error {
+		return f(msgs)
+	}
+	t.Cleanup(func() { rdwrIoctl = saved })
+}
+
+// msgBytes returns the buffer of a message.
+func msgBytes(msg i2c_msg) []byte {
+	return unsafe.Slice((*byte)(msg.buf), msg.len)
+}
+
+func TestReadBlockPEC(t *testing.T) {
+	block := []byte("ABC")
+	// smart battery at 0x0B sending its manufacturer name from register 0x20
+	pec := SMBusPEC(0x0B, append([]byte{0x20, 0x0B<<1 | 1, byte(len(block))}, block...))
+	for _, corrupt := range []bool{false, true} {
+		fakeRDWR(t, func(msgs []i2c_msg) error {
+			if len(msgs) != 2 || msgBytes(msgs[0])[0] != 0x20 {
+				t.Fatalf("unexpected messages %+v", msgs)
+			}
+			buf := msgBytes(msgs[1])
+			buf[0] = byte(len(block))
+			copy(buf[1:], block)
+			buf[1+len(block)] = pec
+			if corrupt {
+				buf[1] ^= 0x01
+			}
+			return nil
+		})
+
+		data, err := (&I2C{address: 0x0B}).ReadBlockPEC(0x20)
+		if corrupt {
+			if err == nil {
+				t.Error("ReadBlockPEC accepted a corrupted block")
+			}
+			continue
+		}
+		if err != nil || string(data) != "ABC" {
+			t.Errorf("ReadBlockPEC = %q, %v", data, err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package i2c
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Flags decodes the bits of a status or configuration register value.
+// Names maps flag names to their bit numbers and may be nil.
+type Flags struct {
+	Value uint32
+	Names map[string]int
+}
+
+func NewFlags(value uint32, names map[string]int) Flags {
+	return Flags{Value: value, Names: names}
+}
+
+// IsSet returns if bit is set in the value.
+func (flags Flags) IsSet(bit int) bool {
+	return bit >= 0 && bit < 32 && flags.Value&(1<<uint(bit)) != 0
+}
+
+// Decode returns the state of all named flags.
+func (flags Flags) Decode() map[string]bool {
+	result := make(map[string]bool, len(flags.Names))
+	for name, bit := range flags.Names {
+		result[name] = flags.IsSet(bit)
+	}
+	return result
+}
+
+// String returns the set flags from the lowest to the highest bit
+// separated by "|". Set bits without a name are rendered as "bitN".
+func (flags Flags) String() string {
+	names := make(map[int][]string)
+	for name, bit := range flags.Names {
+		names[bit] = append(names[bit], name)
+	}
+	var set []string
+	for bit := 0; bit < 32; bit++ {
+		if !flags.IsSet(bit) {
+			continue
+		}
+		if len(names[bit]) == 0 {
+			set = append(set, fmt.Sprintf("bit%d", bit))
+			continue
+		}
+		sort.Strings(names[bit])
+		set = append(set, names[bit]...)
+	}
+	return strings.Join(set, "|")
+}
@@ -0,0 +1,60 @@
+package i2c
+
+// #include <linux/i2c-dev.h>
+import "C"
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Functionality flags of an adapter as reported by Functionality,
+// see <linux/i2c.h>.
+const (
+	FUNC_I2C                    uint64 = 0x00000001
+	FUNC_10BIT_ADDR             uint64 = 0x00000002 /* required for M_TEN */
+	FUNC_PROTOCOL_MANGLING      uint64 = 0x00000004
+	FUNC_SMBUS_PEC              uint64 = 0x00000008
+	FUNC_NOSTART                uint64 = 0x00000010 /* required for M_NOSTART */
+	FUNC_SLAVE                  uint64 = 0x00000020
+	FUNC_SMBUS_BLOCK_PROC_CALL  uint64 = 0x00008000
+	FUNC_SMBUS_QUICK            uint64 = 0x00010000
+	FUNC_SMBUS_READ_BYTE        uint64 = 0x00020000
+	FUNC_SMBUS_WRITE_BYTE       uint64 = 0x00040000
+	FUNC_SMBUS_READ_BYTE_DATA   uint64 = 0x00080000
+	FUNC_SMBUS_WRITE_BYTE_DATA  uint64 = 0x00100000
+	FUNC_SMBUS_READ_WORD_DATA   uint64 = 0x00200000
+	FUNC_SMBUS_WRITE_WORD_DATA  uint64 = 0x00400000
+	FUNC_SMBUS_PROC_CALL        uint64 = 0x00800000
+	FUNC_SMBUS_READ_BLOCK_DATA  uint64 = 0x01000000
+	FUNC_SMBUS_WRITE_BLOCK_DATA uint64 = 0x02000000
+	FUNC_SMBUS_READ_I2C_BLOCK   uint64 = 0x04000000
+	FUNC_SMBUS_WRITE_I2C_BLOCK  uint64 = 0x08000000
+	FUNC_SMBUS_HOST_NOTIFY      uint64 = 0x10000000
+
+	FUNC_SMBUS_BYTE       = FUNC_SMBUS_READ_BYTE | FUNC_SMBUS_WRITE_BYTE
+	FUNC_SMBUS_BYTE_DATA  = FUNC_SMBUS_READ_BYTE_DATA | FUNC_SMBUS_WRITE_BYTE_DATA
+	FUNC_SMBUS_WORD_DATA  = FUNC_SMBUS_READ_WORD_DATA | FUNC_SMBUS_WRITE_WORD_DATA
+	FUNC_SMBUS_BLOCK_DATA = FUNC_SMBUS_READ_BLOCK_DATA | FUNC_SMBUS_WRITE_BLOCK_DATA
+	FUNC_SMBUS_I2C_BLOCK  = FUNC_SMBUS_READ_I2C_BLOCK | FUNC_SMBUS_WRITE_I2C_BLOCK
+)
+
+// Functionality returns the bitmask of the transactions
+// supported by the adapter, see the FUNC_* flags.
+func (i2c *I2C) Functionality() (uint64, error) {
+	var funcs C.ulong
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_FUNCS, uintptr(unsafe.Pointer(&funcs)))
+	if int(result) == -1 {
+		return 0, Err{"Functionality", errno}
+	}
+	return uint64(funcs), nil
+}
+
+// Supports returns if the adapter supports all FUNC_* flags of flag.
+func (i2c *I2C) Supports(flag uint64) (bool, error) {
+	funcs, err := i2c.Functionality()
+	if err != nil {
+		return false, wrapErr("Supports", err)
+	}
+	return funcs&flag == flag, nil
+}
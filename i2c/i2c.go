@@ -6,9 +6,13 @@ package i2c
 import "C"
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
+	"runtime"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
@@ -25,6 +29,10 @@ func (err Err) Error() string {
 	return fmt.Sprintf("I2C.%s error: %s", err.method, err.cause)
 }
 
+func (err Err) Unwrap() error {
+	return err.cause
+}
+
 func wrapErr(method string, err error) error {
 	if err == nil {
 		return nil
@@ -36,10 +44,35 @@ func wrapErr(method string, err error) error {
 	return Err{method, err}
 }
 
+// Bus is the interface of the common register access methods
+// implemented by I2C. Code depending on Bus instead of *I2C
+// can be tested with i2ctest.MockBus.
+type Bus interface {
+	Address() int
+	SetAddress(address int) error
+	ReadUint8() (uint8, error)
+	WriteUint8(value uint8) error
+	ReadUint8Reg(register uint8) (uint8, error)
+	WriteUint8Reg(register uint8, value uint8) error
+	ReadUint16Reg(register uint8) (uint16, error)
+	WriteUint16Reg(register uint8, value uint16) error
+	ReadBlock(register uint8) ([]byte, error)
+	WriteBlock(register uint8, block []byte) error
+	ReadI2CBlock(register uint8, length int) ([]byte, error)
+	WriteI2CBlock(register uint8, block []byte) error
+	Close() error
+}
+
+var _ Bus = (*I2C)(nil)
+
 // I2C is a port of https://github.com/bivab/smbus-cffi/
 type I2C struct {
-	file    *os.File
-	address int
+	file       *os.File
+	address    int
+	reg16Order binary.ByteOrder
+	settle     time.Duration
+	tenBit     bool
+	pec        bool
 }
 
 // Connects the object to the specified SMBus.
@@ -68,35 +101,367 @@ func (i2c *I2C) Address() int {
 	return i2c.address
 }
 
+// addressIoctl sets the slave address of the device file fd with the
+// I2C_SLAVE or I2C_SLAVE_FORCE ioctl request. It is a variable so that
+// tests can replace the kernel with a fake bus.
+var addressIoctl = func(fd, request uintptr, address int) error {
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(address))
+	if result != 0 {
+		return errno
+	}
+	return nil
+}
+
+// SetAddress sets the slave address for all following transactions.
+// Valid addresses are 0x00 to 0x7F, or 0x000 to 0x3FF
+// with ten-bit addressing enabled by SetTenBit.
 func (i2c *I2C) SetAddress(address int) error {
+	if err := i2c.checkAddress(address); err != nil {
+		return Err{"SetAddress", err}
+	}
 	if address != i2c.address {
-		result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_SLAVE, uintptr(address))
-		if result != 0 {
-			return Err{"SetAddress", errno}
+		err := addressIoctl(i2c.file.Fd(), C.I2C_SLAVE, address)
+		if err != nil {
+			return Err{"SetAddress", err}
 		}
 		i2c.address = address
 	}
 	return nil
 }
 
-func (i2c *I2C) smbusAccess(readWrite, register uint8, size int, data unsafe.Pointer) (uintptr, error) {
+// SetAddressForce works like SetAddress, but uses the I2C_SLAVE_FORCE ioctl
+// that also succeeds if a kernel driver is bound to the device,
+// where SetAddress fails with EBUSY.
+// Use with care: accessing the device bypasses the kernel driver,
+// which doesn't expect its device state to change behind its back.
+func (i2c *I2C) SetAddressForce(address int) error {
+	if err := i2c.checkAddress(address); err != nil {
+		return Err{"SetAddressForce", err}
+	}
+	err := addressIoctl(i2c.file.Fd(), C.I2C_SLAVE_FORCE, address)
+	if err != nil {
+		return Err{"SetAddressForce", err}
+	}
+	i2c.address = address
+	return nil
+}
+
+func (i2c *I2C) checkAddress(address int) error {
+	max := 0x7F
+	if i2c.tenBit {
+		max = 0x3FF
+	}
+	if address < 0 || address > max {
+		return fmt.Errorf("Address is 0x%X, but must be in the range 0x00 to 0x%X", address, max)
+	}
+	return nil
+}
+
+// TenBit returns if ten-bit addressing is enabled.
+func (i2c *I2C) TenBit() bool {
+	return i2c.tenBit
+}
+
+// SetTenBit enables or disables ten-bit slave addresses
+// with the I2C_TENBIT ioctl. The adapter has to support FUNC_10BIT_ADDR.
+// Disabling it fails if the current address needs ten bits.
+func (i2c *I2C) SetTenBit(enabled bool) error {
+	if !enabled && i2c.address > 0x7F {
+		return Err{"SetTenBit", fmt.Errorf("Address 0x%X needs ten-bit addressing", i2c.address)}
+	}
+	value := 0
+	if enabled {
+		value = 1
+	}
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_TENBIT, uintptr(value))
+	if result != 0 {
+		return Err{"SetTenBit", errno}
+	}
+	i2c.tenBit = enabled
+	return nil
+}
+
+// PEC returns if SMBus Packet Error Checking is enabled.
+func (i2c *I2C) PEC() bool {
+	return i2c.pec
+}
+
+// SetPEC enables or disables SMBus Packet Error Checking
+// with the I2C_PEC ioctl. While enabled, the kernel appends the PEC
+// to SMBus writes and checks it on reads, so the SMBus methods work
+// unchanged. Enabling it fails if the adapter doesn't support FUNC_SMBUS_PEC,
+// see SMBusPEC for checking the PEC in software instead.
+func (i2c *I2C) SetPEC(enabled bool) error {
+	if enabled {
+		ok, err := i2c.Supports(FUNC_SMBUS_PEC)
+		if err != nil {
+			return wrapErr("SetPEC", err)
+		}
+		if !ok {
+			return Err{"SetPEC", fmt.Errorf("Adapter does not support PEC")}
+		}
+	}
+	value := 0
+	if enabled {
+		value = 1
+	}
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_PEC, uintptr(value))
+	if result != 0 {
+		return Err{"SetPEC", errno}
+	}
+	i2c.pec = enabled
+	return nil
+}
+
+// SetRetries sets how often the adapter retries a transaction
+// when the device doesn't acknowledge, with the I2C_RETRIES ioctl.
+// Not all adapter drivers use it.
+func (i2c *I2C) SetRetries(count int) error {
+	if count < 0 {
+		return Err{"SetRetries", fmt.Errorf("Retry count %d must not be negative", count)}
+	}
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_RETRIES, uintptr(count))
+	if result != 0 {
+		return wrapErr("SetRetries", errno)
+	}
+	return nil
+}
+
+// SetTimeout sets the timeout of transactions with the I2C_TIMEOUT ioctl.
+// The kernel uses units of 10ms, so d is rounded up to a multiple of 10ms.
+func (i2c *I2C) SetTimeout(d time.Duration) error {
+	if d <= 0 {
+		return Err{"SetTimeout", fmt.Errorf("Timeout %s must be positive", d)}
+	}
+	units := (d + 10*time.Millisecond - 1) / (10 * time.Millisecond)
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_TIMEOUT, uintptr(units))
+	if result != 0 {
+		return wrapErr("SetTimeout", errno)
+	}
+	return nil
+}
+
+// withAddress calls f with the slave address temporarily set to address
+// and restores the previous address afterwards.
+func (i2c *I2C) withAddress(address int, f func() error) error {
+	previous := i2c.address
+	err := i2c.SetAddress(address)
+	if err != nil {
+		return err
+	}
+	err = f()
+	if previous >= 0 {
+		if e := i2c.SetAddress(previous); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// smbusIoctl performs an SMBus transaction with the I2C_SMBUS ioctl
+// on the device file fd. It is a variable so that tests
+// can replace the kernel with a fake device.
+var smbusIoctl = func(fd uintptr, readWrite, register uint8, size int, data unsafe.Pointer) (uintptr, error) {
 	args := C.struct_i2c_smbus_ioctl_data{
 		read_write: C.char(readWrite),
 		command:    C.__u8(register),
 		size:       C.int(size),
 		data:       (*C.union_i2c_smbus_data)(data),
 	}
-	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), C.I2C_SMBUS, uintptr(unsafe.Pointer(&args)))
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, C.I2C_SMBUS, uintptr(unsafe.Pointer(&args)))
 	if int(result) == -1 {
 		return 0, errno
 	}
 	return result, nil
 }
 
+func (i2c *I2C) smbusAccess(readWrite, register uint8, size int, data unsafe.Pointer) (uintptr, error) {
+	result, err := smbusIoctl(i2c.file.Fd(), readWrite, register, size, data)
+	if err != nil {
+		return 0, err
+	}
+	if readWrite == C.I2C_SMBUS_WRITE && size != C.I2C_SMBUS_PROC_CALL && size != C.I2C_SMBUS_BLOCK_PROC_CALL {
+		i2c.waitWriteSettle()
+	}
+	return result, nil
+}
+
+// SetWriteSettle sets a delay that every write operation sleeps
+// after writing successfully, for devices like EEPROMs that need
+// their write cycle time before the next transaction.
+// It adds the delay to the latency of every write.
+// The default of zero doesn't delay.
+func (i2c *I2C) SetWriteSettle(d time.Duration) {
+	i2c.settle = d
+}
+
+func (i2c *I2C) waitWriteSettle() {
+	if i2c.settle > 0 {
+		time.Sleep(i2c.settle)
+	}
+}
+
+// maxMsgLen is the maximum length of a single I2C_RDWR message
+// accepted by the kernel.
+const maxMsgLen = 8192
+
+type i2c_msg struct {
+	addr  uint16
+	flags uint16
+	len   uint16
+	buf   unsafe.Pointer
+}
+
+type i2c_rdwr_ioctl_data struct {
+	msgs  unsafe.Pointer
+	nmsgs uint32
+}
+
+// rdwrIoctl performs the messages with the I2C_RDWR ioctl
+// on the device file fd. It is a variable so that tests
+// can replace the kernel with a fake device.
+var rdwrIoctl = func(fd uintptr, msgs []i2c_msg) error {
+	data := i2c_rdwr_ioctl_data{
+		msgs:  unsafe.Pointer(&msgs[0]),
+		nmsgs: uint32(len(msgs)),
+	}
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, C.I2C_RDWR, uintptr(unsafe.Pointer(&data)))
+	runtime.KeepAlive(msgs)
+	if int(result) == -1 {
+		return errno
+	}
+	return nil
+}
+
+// rdwr performs the messages as one combined transaction
+// with repeated starts using the I2C_RDWR ioctl.
+func (i2c *I2C) rdwr(msgs []i2c_msg) error {
+	if i2c.tenBit {
+		for i := range msgs {
+			msgs[i].flags |= C.I2C_M_TEN
+		}
+	}
+	if err := rdwrIoctl(i2c.file.Fd(), msgs); err != nil {
+		return err
+	}
+	for _, msg := range msgs {
+		if msg.flags&C.I2C_M_RD != 0 {
+			return nil
+		}
+	}
+	i2c.waitWriteSettle()
+	return nil
+}
+
+// readRegister writes the register address bytes reg and
+// reads n bytes after a repeated start in one combined transaction.
+func (i2c *I2C) readRegister(reg []byte, n int) ([]byte, error) {
+	if n < 1 || n > maxMsgLen {
+		return nil, fmt.Errorf("Read length is %d, but must be in the range 1 to %d", n, maxMsgLen)
+	}
+	data := make([]byte, n)
+	err := i2c.rdwr([]i2c_msg{
+		{addr: uint16(i2c.address), len: uint16(len(reg)), buf: unsafe.Pointer(&reg[0])},
+		{addr: uint16(i2c.address), flags: C.I2C_M_RD, len: uint16(n), buf: unsafe.Pointer(&data[0])},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Flags of a Message.
+const (
+	M_RD      uint16 = 0x0001 /* read data from slave to master */
+	M_TEN     uint16 = 0x0010 /* ten-bit address, requires FUNC_10BIT_ADDR */
+	M_NOSTART uint16 = 0x4000 /* no repeated start, requires FUNC_NOSTART */
+)
+
+// Message is a part of a combined transaction performed by Transfer.
+type Message struct {
+	Addr  uint16
+	Flags uint16
+	// Buf contains the data to write,
+	// or receives the data for a message with M_RD
+	Buf []byte
+}
+
+// Transfer performs msgs as one combined transaction with the I2C_RDWR ioctl,
+// with repeated starts instead of stop conditions between the messages,
+// for example to write a register pointer and read from it atomically.
+// The kernel limits a transaction to 42 messages.
+func (i2c *I2C) Transfer(msgs []Message) error {
+	if len(msgs) == 0 || len(msgs) > C.I2C_RDRW_IOCTL_MAX_MSGS {
+		return wrapErr("Transfer", fmt.Errorf("Number of messages is %d, but must be in the range 1 to %d", len(msgs), C.I2C_RDRW_IOCTL_MAX_MSGS))
+	}
+	cMsgs := make([]i2c_msg, len(msgs))
+	for i, msg := range msgs {
+		if len(msg.Buf) > maxMsgLen {
+			return wrapErr("Transfer", fmt.Errorf("Length of message %d is %d, but must not exceed %d", i, len(msg.Buf), maxMsgLen))
+		}
+		cMsgs[i] = i2c_msg{addr: msg.Addr, flags: msg.Flags, len: uint16(len(msg.Buf))}
+		if len(msg.Buf) > 0 {
+			cMsgs[i].buf = unsafe.Pointer(&msg.Buf[0])
+		}
+	}
+	err := i2c.rdwr(cMsgs)
+	runtime.KeepAlive(msgs)
+	return wrapErr("Transfer", err)
+}
+
+// ReadRegisters reads the single byte registers regs in one combined
+// transaction of alternating register writes and byte reads
+// with repeated starts, so that for example the X, Y and Z registers
+// of a sensor are sampled as close together in time as possible.
+// The kernel limits a combined transaction to 21 registers.
+// On SMBus-only adapters without I2C_RDWR support the registers
+// are read one after the other with ReadUint8Reg,
+// so the values can belong to different samples.
+func (i2c *I2C) ReadRegisters(regs []uint8) ([]uint8, error) {
+	if len(regs) == 0 || len(regs) > C.I2C_RDRW_IOCTL_MAX_MSGS/2 {
+		return nil, wrapErr("ReadRegisters", fmt.Errorf("Number of registers is %d, but must be in the range 1 to %d", len(regs), C.I2C_RDRW_IOCTL_MAX_MSGS/2))
+	}
+	reg := append([]uint8(nil), regs...)
+	values := make([]uint8, len(regs))
+	msgs := make([]i2c_msg, 0, 2*len(regs))
+	for i := range reg {
+		msgs = append(msgs,
+			i2c_msg{addr: uint16(i2c.address), len: 1, buf: unsafe.Pointer(&reg[i])},
+			i2c_msg{addr: uint16(i2c.address), flags: C.I2C_M_RD, len: 1, buf: unsafe.Pointer(&values[i])},
+		)
+	}
+	err := i2c.rdwr(msgs)
+	if err == syscall.EOPNOTSUPP {
+		for i, r := range regs {
+			values[i], err = i2c.ReadUint8Reg(r)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, wrapErr("ReadRegisters", err)
+	}
+	return values, nil
+}
+
 // WriteQuick sends a single bit to the device, at the place of the Rd/Wr bit.
 func (i2c *I2C) WriteQuick(value uint8) error {
 	_, err := i2c.smbusAccess(value, 0, C.I2C_SMBUS_QUICK, nil)
-	return wrapErr("SetAddress", err)
+	return wrapErr("WriteQuick", err)
+}
+
+// QuickRead sends a SMBus quick command with the R/W bit set to read.
+// It can be used to probe for devices that don't ACK quick writes.
+func (i2c *I2C) QuickRead() error {
+	return wrapErr("QuickRead", i2c.WriteQuick(C.I2C_SMBUS_READ))
+}
+
+// QuickWrite sends a SMBus quick command with the R/W bit cleared to write.
+// Some devices like EEPROMs can take it as the start of a write
+// and corrupt data, see Present.
+func (i2c *I2C) QuickWrite() error {
+	return wrapErr("QuickWrite", i2c.WriteQuick(C.I2C_SMBUS_WRITE))
 }
 
 // ReadUint8 reads a single byte from a device, without specifying a device
@@ -157,6 +522,141 @@ func (i2c *I2C) WriteInt8Reg(register uint8, value int8) error {
 	return wrapErr("WriteInt8Reg", i2c.WriteUint8Reg(register, uint8(value)))
 }
 
+// ProbeWritable checks if a register is writable by reading its value,
+// writing it with all bits inverted, reading it back and restoring
+// the original value. It returns true if the inverted value was read back,
+// see ProbeWritableMask for registers with reserved or read-only bits.
+// Once the inverted value was written, the original value is restored
+// even if the read back fails, and a failed restore is returned as error.
+//
+// Use with care: writing registers can have side effects
+// like triggering commands or resets, and registers may read back
+// different values than written even if the write had an effect.
+// The I2C object is not safe for concurrent use, so other users
+// of the bus must be kept from interfering while probing.
+func (i2c *I2C) ProbeWritable(register uint8) (bool, error) {
+	return i2c.probeWritable("ProbeWritable", register, 0xFF)
+}
+
+// ProbeWritableMask works like ProbeWritable, but only inverts
+// and compares the bits of mask. The bits outside of mask are written
+// unchanged and not compared, so reserved and read-only bits
+// can be excluded by mask. mask must not be zero.
+func (i2c *I2C) ProbeWritableMask(register, mask uint8) (bool, error) {
+	if mask == 0 {
+		return false, Err{"ProbeWritableMask", fmt.Errorf("Mask must not be zero")}
+	}
+	return i2c.probeWritable("ProbeWritableMask", register, mask)
+}
+
+func (i2c *I2C) probeWritable(method string, register, mask uint8) (writable bool, err error) {
+	original, err := i2c.ReadUint8Reg(register)
+	if err != nil {
+		return false, wrapErr(method, err)
+	}
+	pattern := original ^ mask
+	err = i2c.WriteUint8Reg(register, pattern)
+	if err != nil {
+		return false, wrapErr(method, err)
+	}
+	defer func() {
+		err = errors.Join(err, wrapErr(method, i2c.WriteUint8Reg(register, original)))
+		if err != nil {
+			writable = false
+		}
+	}()
+	readBack, err := i2c.ReadUint8Reg(register)
+	if err != nil {
+		return false, wrapErr(method, err)
+	}
+	return readBack&mask == pattern&mask, nil
+}
+
+// UpdateBits sets the bits of mask in a register to the bits of value
+// by reading the register and writing back the modified value.
+// The write is skipped if the register already has the value.
+// The I2C object is not safe for concurrent use, so other users
+// of the device must be kept from interfering between read and write.
+func (i2c *I2C) UpdateBits(register, mask, value uint8) error {
+	_, err := i2c.updateBits(register, mask, value)
+	return wrapErr("UpdateBits", err)
+}
+
+// UpdateBitsVerify works like UpdateBits, but reads the register back
+// after writing and returns an error if the bits of mask don't match value,
+// to catch writes that were silently lost on a flaky bus.
+// Use UpdateBits for registers that don't read back what was written.
+func (i2c *I2C) UpdateBitsVerify(register, mask, value uint8) error {
+	written, err := i2c.updateBits(register, mask, value)
+	if err != nil {
+		return wrapErr("UpdateBitsVerify", err)
+	}
+	readBack, err := i2c.ReadUint8Reg(register)
+	if err != nil {
+		return wrapErr("UpdateBitsVerify", err)
+	}
+	if readBack&mask != written&mask {
+		return wrapErr("UpdateBitsVerify", fmt.Errorf("register 0x%02X reads back 0x%02X instead of 0x%02X with mask 0x%02X", register, readBack, written, mask))
+	}
+	return nil
+}
+
+func (i2c *I2C) updateBits(register, mask, value uint8) (uint8, error) {
+	original, err := i2c.ReadUint8Reg(register)
+	if err != nil {
+		return 0, err
+	}
+	modified := original&^mask | value&mask
+	if modified == original {
+		return modified, nil
+	}
+	return modified, i2c.WriteUint8Reg(register, modified)
+}
+
+// DumpRegisters reads all 256 registers of the device
+// with ReadUint8Reg, starting at register 0x00.
+// If a read fails, the error names the failed register.
+func (i2c *I2C) DumpRegisters() (registers [256]uint8, err error) {
+	for i := range registers {
+		registers[i], err = i2c.ReadUint8Reg(uint8(i))
+		if err != nil {
+			return registers, wrapErr("DumpRegisters", fmt.Errorf("register 0x%02X: %w", i, err))
+		}
+	}
+	return registers, nil
+}
+
+// AlertResponseAddress is the SMBus Alert Response Address (ARA).
+const AlertResponseAddress = 0x0C
+
+// ReceiveAlert performs the SMBus Alert Response Address read
+// to find out which device asserted the SMBALERT# line.
+// The responding device sends its 7 bit address in the upper bits
+// and a device specific status in the lowest bit.
+// If several devices assert the alert, the one with the lowest
+// address wins the arbitration and the others keep the line asserted.
+//
+// The alert line has to be wired, and noticing the alert interrupt
+// is up to the caller, for example with the gpio package.
+// The ARA read fails if the kernel smbus_alert driver owns the address.
+func (i2c *I2C) ReceiveAlert() (address int, status uint8, err error) {
+	var response uint8
+	err = i2c.withAddress(AlertResponseAddress, func() (err error) {
+		response, err = i2c.ReadUint8()
+		return err
+	})
+	if err != nil {
+		return 0, 0, wrapErr("ReceiveAlert", err)
+	}
+	return int(response >> 1), response & 1, nil
+}
+
+// Note: SMBus Host Notify messages, that devices send as bus master
+// to the host address 0x08, are received by the kernel's i2c-smbus driver
+// and delivered as interrupt to the kernel driver bound to the device.
+// The i2c-dev interface used by this package offers no way to receive
+// them in user space, so there is no counterpart to ReceiveAlert for them.
+
 // ReadUint16Reg is very like ReadUint8Reg; again, data is read from a
 // device, from a designated register.
 // But this time, the data is a complete word (16 bits).
@@ -175,6 +675,23 @@ func (i2c *I2C) WriteUint16Reg(register uint8, value uint16) error {
 	return wrapErr("WriteUint16Reg", err)
 }
 
+// ReadWordWithCRC reads a 16 bit word from a designated register
+// followed by a CRC-8 byte, as sent by Sensirion sensors like SHT3x and SCD30.
+// The word is sent MSB first and protected by the Sensirion CRC-8
+// with polynomial 0x31 and initial value 0xFF.
+// An error is returned if the CRC does not match.
+func (i2c *I2C) ReadWordWithCRC(register uint8) (uint16, error) {
+	data, err := i2c.readRegister([]byte{register}, 3)
+	if err != nil {
+		return 0, wrapErr("ReadWordWithCRC", err)
+	}
+	crc := CRC8(data[:2], SensirionCRC8Poly, SensirionCRC8Init)
+	if crc != data[2] {
+		return 0, wrapErr("ReadWordWithCRC", fmt.Errorf("CRC mismatch, received 0x%02X but calculated 0x%02X", data[2], crc))
+	}
+	return uint16(data[0])<<8 | uint16(data[1]), nil
+}
+
 // ReadUint16RegSwapped is very like ReadUint8Reg; again, data is read from a
 // device, from a designated register. But this time, the data is a complete word (16 bits).
 // The bytes of the 16 bit value will be swapped.
@@ -236,12 +753,35 @@ func (i2c *I2C) ProcessCallSwapped(register uint8, value uint16) (uint16, error)
 	return SwapBytes(result), wrapErr("ProcessCallSwapped", err)
 }
 
+// MaxBlockSize returns the maximum length of a SMBus block transfer,
+// which the block transfer methods validate against.
+// The kernel does not report adapter specific block limits,
+// so this is always the SMBus maximum of 32 bytes.
+func (i2c *I2C) MaxBlockSize() int {
+	return C.I2C_SMBUS_BLOCK_MAX
+}
+
+// ValidBlockLength returns if n is a valid length
+// of a SMBus block transfer from 1 to 32 bytes.
+func ValidBlockLength(n int) bool {
+	return n >= 1 && n <= C.I2C_SMBUS_BLOCK_MAX
+}
+
+// validateBlockLen returns an error if n is not a valid length
+// of a block transfer of the adapter, see MaxBlockSize.
+func (i2c *I2C) validateBlockLen(n int) error {
+	if max := i2c.MaxBlockSize(); n < 1 || n > max {
+		return fmt.Errorf("Length of block is %d, but must be in the range 1 to %d", n, max)
+	}
+	return nil
+}
+
 // ProcessCallBlock reads a block of up to 32 bytes from a device, from a
 // designated register.
 func (i2c *I2C) ProcessCallBlock(register uint8, block []byte) ([]byte, error) {
 	length := len(block)
-	if length == 0 || length > C.I2C_SMBUS_BLOCK_MAX {
-		return nil, wrapErr("ProcessCallBlock", fmt.Errorf("Length of block is %d, but must be in the range 1 to %d", length, C.I2C_SMBUS_BLOCK_MAX))
+	if err := i2c.validateBlockLen(length); err != nil {
+		return nil, wrapErr("ProcessCallBlock", err)
 	}
 	data := make([]byte, length+1, C.I2C_SMBUS_BLOCK_MAX+2)
 	data[0] = byte(length)
@@ -253,6 +793,35 @@ func (i2c *I2C) ProcessCallBlock(register uint8, block []byte) ([]byte, error) {
 	return data[1 : 1+data[0]], nil
 }
 
+// ProcessCallBlockN works like ProcessCallBlock, but returns exactly
+// expectLen bytes of the response regardless of the length byte
+// sent by the device. Prefer it over ProcessCallBlock for devices
+// that return a known fixed count with an unreliable length byte.
+// The transaction is performed with I2C_RDWR as write of the register,
+// the length and the block, followed by a read of the length byte
+// and expectLen bytes after a repeated start.
+func (i2c *I2C) ProcessCallBlockN(register uint8, block []byte, expectLen int) ([]byte, error) {
+	if err := i2c.validateBlockLen(len(block)); err != nil {
+		return nil, wrapErr("ProcessCallBlockN", err)
+	}
+	if err := i2c.validateBlockLen(expectLen); err != nil {
+		return nil, wrapErr("ProcessCallBlockN", err)
+	}
+	tx := make([]byte, 2+len(block))
+	tx[0] = register
+	tx[1] = byte(len(block))
+	copy(tx[2:], block)
+	rx := make([]byte, 1+expectLen)
+	err := i2c.rdwr([]i2c_msg{
+		{addr: uint16(i2c.address), len: uint16(len(tx)), buf: unsafe.Pointer(&tx[0])},
+		{addr: uint16(i2c.address), flags: C.I2C_M_RD, len: uint16(len(rx)), buf: unsafe.Pointer(&rx[0])},
+	})
+	if err != nil {
+		return nil, wrapErr("ProcessCallBlockN", err)
+	}
+	return rx[1:], nil
+}
+
 // ReadBlock writes up to 32 bytes to a device, to a designated register.
 func (i2c *I2C) ReadBlock(register uint8) ([]byte, error) {
 	data := make([]byte, C.I2C_SMBUS_BLOCK_MAX+2)
@@ -263,12 +832,43 @@ func (i2c *I2C) ReadBlock(register uint8) ([]byte, error) {
 	return data[1 : 1+data[0]], nil
 }
 
+// ReadBlockPEC reads a block of up to 32 bytes from a device, from a
+// designated register, followed by the PEC byte, and verifies the PEC
+// in software with SMBusPEC, for adapters without hardware PEC support.
+// The PEC covers the address byte with the R/W bit cleared, the register,
+// the address byte with the R/W bit set, the count and the block.
+// The adapter has to support I2C_M_RECV_LEN.
+func (i2c *I2C) ReadBlockPEC(register uint8) ([]byte, error) {
+	reg := []byte{register}
+	// buf[0] tells the kernel the number of bytes to read
+	// in addition to the block: the count and the PEC
+	buf := make([]byte, C.I2C_SMBUS_BLOCK_MAX+2)
+	buf[0] = 2
+	err := i2c.rdwr([]i2c_msg{
+		{addr: uint16(i2c.address), len: 1, buf: unsafe.Pointer(&reg[0])},
+		{addr: uint16(i2c.address), flags: C.I2C_M_RD | C.I2C_M_RECV_LEN, len: uint16(len(buf)), buf: unsafe.Pointer(&buf[0])},
+	})
+	if err != nil {
+		return nil, wrapErr("ReadBlockPEC", err)
+	}
+	count := int(buf[0])
+	if err := i2c.validateBlockLen(count); err != nil {
+		return nil, wrapErr("ReadBlockPEC", err)
+	}
+	addr := uint8(i2c.address)
+	pec := SMBusPEC(addr, append([]byte{register, addr<<1 | 1}, buf[:1+count]...))
+	if pec != buf[1+count] {
+		return nil, wrapErr("ReadBlockPEC", fmt.Errorf("PEC mismatch: received 0x%02X, calculated 0x%02X", buf[1+count], pec))
+	}
+	return buf[1 : 1+count], nil
+}
+
 // WriteBlock selects a device register, sends
 // 1 to 31 bytes of data to it, and reads 1 to 31 bytes of data in return.
 func (i2c *I2C) WriteBlock(register uint8, block []byte) error {
 	length := len(block)
-	if length == 0 || length > C.I2C_SMBUS_BLOCK_MAX {
-		return wrapErr("WriteBlock", fmt.Errorf("Length of block is %d, but must be in the range 1 to %d", length, C.I2C_SMBUS_BLOCK_MAX))
+	if err := i2c.validateBlockLen(length); err != nil {
+		return wrapErr("WriteBlock", err)
 	}
 	data := make([]byte, length+1)
 	data[0] = byte(length)
@@ -277,8 +877,266 @@ func (i2c *I2C) WriteBlock(register uint8, block []byte) error {
 	return wrapErr("WriteBlock", err)
 }
 
-// TODO: Perform I2C Block Read transaction.
-// With if len == 32 then arg = C.I2C_SMBUS_I2C_BLOCK_BROKEN instead of I2C_SMBUS_I2C_BLOCK_DATA ???
+// ReadAutoIncrement reads n bytes starting at a register of devices that
+// only auto-increment the register address during multi-byte reads
+// if a bit of the register address is set, like the MSB for LSM303 and LIS3DH.
+// incrementMask is ORed into register, commonly 0x80.
+// The register is written and the data read in one combined transaction
+// with a repeated start.
+// Which bit enables the auto-increment is specific to the device family,
+// see the datasheet.
+func (i2c *I2C) ReadAutoIncrement(register uint8, n int, incrementMask uint8) ([]byte, error) {
+	data, err := i2c.readRegister([]byte{register | incrementMask}, n)
+	return data, wrapErr("ReadAutoIncrement", err)
+}
+
+// SignExtend interprets the lower bits of value as a two's complement
+// number of the given bit width (1 to 32) and returns it sign-extended.
+func SignExtend(value uint32, bits int) int32 {
+	shift := uint(32 - bits)
+	return int32(value<<shift) >> shift
+}
+
+// ReadSigned reads a signed two's complement value of bits width (1 to 32)
+// starting at a register, as returned by many accelerometers and ADCs.
+// The (bits+7)/8 bytes are read in one combined transaction and
+// assembled in order, the value has to be right-aligned in them.
+// Left-aligned values like 12 bits in the upper bits of 16
+// have to be read with the full width and shifted arithmetically.
+func (i2c *I2C) ReadSigned(register uint8, bits int, order binary.ByteOrder) (int32, error) {
+	if bits < 1 || bits > 32 {
+		return 0, wrapErr("ReadSigned", fmt.Errorf("Bit width is %d, but must be in the range 1 to 32", bits))
+	}
+	n := (bits + 7) / 8
+	data, err := i2c.readRegister([]byte{register}, n)
+	if err != nil {
+		return 0, wrapErr("ReadSigned", err)
+	}
+	buf := make([]byte, 4)
+	if order.Uint16([]byte{0, 1}) == 1 {
+		// big endian, most significant bytes are missing at the front
+		copy(buf[4-n:], data)
+	} else {
+		copy(buf, data)
+	}
+	value := order.Uint32(buf)
+	if bits < 32 {
+		value &= 1<<uint(bits) - 1
+	}
+	return SignExtend(value, bits), nil
+}
+
+// ReadScaled16 reads a signed 16 bit word from a register in the given
+// byte order, shifts it arithmetically right by shift bits and multiplies
+// it by lsb, as needed for temperature sensors like TMP102, LM75 and MCP9808.
+// For example the 12 bit temperature of a TMP102 is read with
+// ReadScaled16(0x00, binary.BigEndian, 4, 0.0625).
+// Status bits below the value have to be removed by shift,
+// flag bits above the sign bit have to be cleared by reading the word
+// with ReadUint16Reg instead. shift must be in the range 0 to 15.
+func (i2c *I2C) ReadScaled16(register uint8, order binary.ByteOrder, shift int, lsb float64) (float64, error) {
+	if shift < 0 || shift > 15 {
+		return 0, wrapErr("ReadScaled16", fmt.Errorf("Shift is %d, but must be in the range 0 to 15", shift))
+	}
+	data, err := i2c.readRegister([]byte{register}, 2)
+	if err != nil {
+		return 0, wrapErr("ReadScaled16", err)
+	}
+	value := int16(order.Uint16(data)) >> uint(shift)
+	return float64(value) * lsb, nil
+}
+
+// SetReg16ByteOrder sets the byte order of the 16 bit register addresses
+// used by ReadReg16 and WriteReg16. The default is binary.BigEndian.
+func (i2c *I2C) SetReg16ByteOrder(order binary.ByteOrder) {
+	i2c.reg16Order = order
+}
+
+func (i2c *I2C) reg16Bytes(register uint16, length int) []byte {
+	order := i2c.reg16Order
+	if order == nil {
+		order = binary.BigEndian
+	}
+	data := make([]byte, 2, 2+length)
+	order.PutUint16(data, register)
+	return data
+}
+
+// ReadReg16 reads n bytes from a device starting at a 16 bit register
+// or memory address, as used by 24xx series EEPROMs.
+// The address is written and the data read in one combined transaction
+// with a repeated start.
+func (i2c *I2C) ReadReg16(register uint16, n int) ([]byte, error) {
+	data, err := i2c.readRegister(i2c.reg16Bytes(register, 0), n)
+	return data, wrapErr("ReadReg16", err)
+}
+
+// WriteReg16 writes data to a device starting at a 16 bit register
+// or memory address, as used by 24xx series EEPROMs.
+// EEPROMs wrap writes around at their page boundary (for example
+// 32 or 64 bytes) and need their write cycle time before the next access,
+// so data must not cross a page boundary of the device.
+func (i2c *I2C) WriteReg16(register uint16, data []byte) error {
+	if len(data) > maxMsgLen-2 {
+		return wrapErr("WriteReg16", fmt.Errorf("Length of data is %d, but must not exceed %d", len(data), maxMsgLen-2))
+	}
+	msg := append(i2c.reg16Bytes(register, len(data)), data...)
+	err := i2c.rdwr([]i2c_msg{
+		{addr: uint16(i2c.address), len: uint16(len(msg)), buf: unsafe.Pointer(&msg[0])},
+	})
+	return wrapErr("WriteReg16", err)
+}
+
+// ReadI2CBlock reads length bytes from 1 to 32 from a device,
+// starting at a designated register.
+// In contrast to ReadBlock, the device sends no length byte,
+// as for burst reads of sensors like the BME280.
+func (i2c *I2C) ReadI2CBlock(register uint8, length int) ([]byte, error) {
+	if err := i2c.validateBlockLen(length); err != nil {
+		return nil, wrapErr("ReadI2CBlock", err)
+	}
+	size := C.I2C_SMBUS_I2C_BLOCK_DATA
+	if length == C.I2C_SMBUS_BLOCK_MAX {
+		size = C.I2C_SMBUS_I2C_BLOCK_BROKEN
+	}
+	data := make([]byte, C.I2C_SMBUS_BLOCK_MAX+2)
+	data[0] = byte(length)
+	_, err := i2c.smbusAccess(C.I2C_SMBUS_READ, register, size, unsafe.Pointer(&data[0]))
+	if err != nil {
+		return nil, wrapErr("ReadI2CBlock", err)
+	}
+	return data[1 : 1+length], nil
+}
+
+// WriteI2CBlock writes 1 to 32 bytes to a device, starting at a
+// designated register. In contrast to WriteBlock, no length byte is sent.
+func (i2c *I2C) WriteI2CBlock(register uint8, block []byte) error {
+	length := len(block)
+	if err := i2c.validateBlockLen(length); err != nil {
+		return wrapErr("WriteI2CBlock", err)
+	}
+	data := make([]byte, C.I2C_SMBUS_BLOCK_MAX+2)
+	data[0] = byte(length)
+	copy(data[1:], block)
+	_, err := i2c.smbusAccess(C.I2C_SMBUS_WRITE, register, C.I2C_SMBUS_I2C_BLOCK_DATA, unsafe.Pointer(&data[0]))
+	return wrapErr("WriteI2CBlock", err)
+}
+
+// readUintN reads an n byte unsigned value with ReadI2CBlock,
+// LSB first like the SMBus words of ReadUint16Reg, or MSB first if swapped.
+func (i2c *I2C) readUintN(method string, register uint8, n int, swapped bool) (uint32, error) {
+	data, err := i2c.ReadI2CBlock(register, n)
+	if err != nil {
+		return 0, wrapErr(method, err)
+	}
+	var value uint32
+	for i := range data {
+		b := data[i]
+		if !swapped {
+			b = data[n-1-i]
+		}
+		value = value<<8 | uint32(b)
+	}
+	return value, nil
+}
+
+// writeUintN writes an n byte unsigned value with WriteI2CBlock,
+// LSB first like the SMBus words of WriteUint16Reg, or MSB first if swapped.
+func (i2c *I2C) writeUintN(method string, register uint8, n int, value uint32, swapped bool) error {
+	if n < 4 && value >= 1<<uint(8*n) {
+		return wrapErr(method, fmt.Errorf("Value 0x%X does not fit into %d bits", value, 8*n))
+	}
+	data := make([]byte, n)
+	for i := range data {
+		shift := uint(8 * i)
+		if swapped {
+			shift = uint(8 * (n - 1 - i))
+		}
+		data[i] = byte(value >> shift)
+	}
+	return wrapErr(method, i2c.WriteI2CBlock(register, data))
+}
+
+// ReadUint24Reg reads a 24 bit value from a device, from a designated register.
+// The bytes are sent LSB first like the words of ReadUint16Reg.
+func (i2c *I2C) ReadUint24Reg(register uint8) (uint32, error) {
+	return i2c.readUintN("ReadUint24Reg", register, 3, false)
+}
+
+// ReadUint24RegSwapped reads a 24 bit value from a device, from a designated register.
+// The bytes are sent MSB first like the words of ReadUint16RegSwapped.
+func (i2c *I2C) ReadUint24RegSwapped(register uint8) (uint32, error) {
+	return i2c.readUintN("ReadUint24RegSwapped", register, 3, true)
+}
+
+// WriteUint24Reg writes a 24 bit value to a device, to a designated register.
+// The bytes are sent LSB first like the words of WriteUint16Reg.
+func (i2c *I2C) WriteUint24Reg(register uint8, value uint32) error {
+	return i2c.writeUintN("WriteUint24Reg", register, 3, value, false)
+}
+
+// WriteUint24RegSwapped writes a 24 bit value to a device, to a designated register.
+// The bytes are sent MSB first like the words of WriteUint16RegSwapped.
+func (i2c *I2C) WriteUint24RegSwapped(register uint8, value uint32) error {
+	return i2c.writeUintN("WriteUint24RegSwapped", register, 3, value, true)
+}
+
+// ReadUint32Reg reads a 32 bit value from a device, from a designated register.
+// The bytes are sent LSB first like the words of ReadUint16Reg.
+func (i2c *I2C) ReadUint32Reg(register uint8) (uint32, error) {
+	return i2c.readUintN("ReadUint32Reg", register, 4, false)
+}
+
+// ReadUint32RegSwapped reads a 32 bit value from a device, from a designated register.
+// The bytes are sent MSB first like the words of ReadUint16RegSwapped.
+func (i2c *I2C) ReadUint32RegSwapped(register uint8) (uint32, error) {
+	return i2c.readUintN("ReadUint32RegSwapped", register, 4, true)
+}
+
+// WriteUint32Reg writes a 32 bit value to a device, to a designated register.
+// The bytes are sent LSB first like the words of WriteUint16Reg.
+func (i2c *I2C) WriteUint32Reg(register uint8, value uint32) error {
+	return i2c.writeUintN("WriteUint32Reg", register, 4, value, false)
+}
+
+// WriteUint32RegSwapped writes a 32 bit value to a device, to a designated register.
+// The bytes are sent MSB first like the words of WriteUint16RegSwapped.
+func (i2c *I2C) WriteUint32RegSwapped(register uint8, value uint32) error {
+	return i2c.writeUintN("WriteUint32RegSwapped", register, 4, value, true)
+}
+
+// Ioctl performs the ioctl request on the I2C device file
+// with arg as argument and returns the result of the syscall.
+// It is an escape hatch for ioctls not supported by this package.
+// Advanced and unsafe: arg must point to memory matching
+// what the kernel expects for request.
+func (i2c *I2C) Ioctl(request uintptr, arg unsafe.Pointer) (uintptr, error) {
+	result, _, errno := syscall.Syscall(syscall.SYS_IOCTL, i2c.file.Fd(), request, uintptr(arg))
+	if int(result) == -1 {
+		return 0, Err{"Ioctl", errno}
+	}
+	return result, nil
+}
+
+// Healthy checks if the device responds by reading a byte from it.
+func (i2c *I2C) Healthy() error {
+	_, err := i2c.ReadUint8()
+	return wrapErr("Healthy", err)
+}
+
+// Present returns if a device responds at the address,
+// probing like i2cdetect: with a byte read for the address ranges
+// 0x30-0x37 and 0x50-0x5F, where a quick write can corrupt EEPROMs,
+// and with a quick write otherwise.
+func (i2c *I2C) Present() bool {
+	var err error
+	if a := i2c.address; a >= 0x30 && a <= 0x37 || a >= 0x50 && a <= 0x5F {
+		_, err = i2c.ReadUint8()
+	} else {
+		err = i2c.QuickWrite()
+	}
+	return err == nil
+}
 
 func (i2c *I2C) Read(p []byte) (n int, err error) {
 	n, err = i2c.file.Read(p)
@@ -287,5 +1145,8 @@ func (i2c *I2C) Read(p []byte) (n int, err error) {
 
 func (i2c *I2C) Write(p []byte) (n int, err error) {
 	n, err = i2c.file.Write(p)
+	if err == nil {
+		i2c.waitWriteSettle()
+	}
 	return n, wrapErr("Write", err)
 }
@@ -0,0 +1,399 @@
+package i2c
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// fakeSMBus replaces the I2C_SMBUS ioctl with f until the end of the test.
+func fakeSMBus(t *testing.T, f func(readWrite, register uint8, size int, data unsafe.Pointer) error) {
+	saved := smbusIoctl
+	smbusIoctl = func(fd uintptr, readWrite, register uint8, size int, data unsafe.Pointer) (uintptr, error) {
+		return 0, f(readWrite, register, size, data)
+	}
+	t.Cleanup(func() { smbusIoctl = saved })
+}
+
+func TestWriteQuickError(t *testing.T) {
+	fakeSMBus(t, func(readWrite, register uint8, size int, data unsafe.Pointer) error {
+		return syscall.EIO
+	})
+
+	err := (&I2C{address: 0x20}).WriteQuick(0)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.Contains(err.Error(), "I2C.WriteQuick") {
+		t.Errorf("error %q does not contain I2C.WriteQuick", err)
+	}
+	if !errors.Is(err, syscall.EIO) {
+		t.Errorf("error %q does not wrap EIO", err)
+	}
+}
+
+func TestQuickDirection(t *testing.T) {
+	tests := []struct {
+		name      string
+		quick     func(i2c *I2C) error
+		readWrite uint8
+	}{
+		{"QuickRead", (*I2C).QuickRead, smbusRead},
+		{"QuickWrite", (*I2C).QuickWrite, smbusWrite},
+		{"WriteQuick(1)", func(i2c *I2C) error { return i2c.WriteQuick(1) }, 1},
+		{"WriteQuick(0)", func(i2c *I2C) error { return i2c.WriteQuick(0) }, 0},
+	}
+	for _, test := range tests {
+		calls := 0
+		fakeSMBus(t, func(readWrite, register uint8, size int, data unsafe.Pointer) error {
+			calls++
+			if readWrite != test.readWrite || size != smbusQuick || data != nil {
+				t.Errorf("%s: sent read_write %d, size %d, data %v", test.name, readWrite, size, data)
+			}
+			return nil
+		})
+		if err := test.quick(&I2C{address: 0x50}); err != nil {
+			t.Errorf("%s returned %v", test.name, err)
+		}
+		if calls != 1 {
+			t.Errorf("%s performed %d transactions", test.name, calls)
+		}
+	}
+}
+
+func TestReceiveAlert(t *testing.T) {
+	var addresses []int
+	saved := addressIoctl
+	addressIoctl = func(fd, request uintptr, address int) error {
+		addresses = append(addresses, address)
+		return nil
+	}
+	defer func() { addressIoctl = saved }()
+
+	i2c := &I2C{address: 0x48}
+	tests := []struct {
+		response uint8
+		address  int
+		status   uint8
+	}{
+		// the fuel gauge at 0x36 responds with its address and status 1
+		{0x36<<1 | 1, 0x36, 1},
+		{0x0B << 1, 0x0B, 0},
+		{0xFF, 0x7F, 1},
+	}
+	for _, test := range tests {
+		addresses = nil
+		fakeSMBus(t, func(readWrite, register uint8, size int, data unsafe.Pointer) error {
+			if i2c.address != AlertResponseAddress || readWrite != smbusRead || size != smbusByte {
+				t.Errorf("read from 0x%02X with read_write %d and size %d instead of a byte read from the ARA", i2c.address, readWrite, size)
+			}
+			*(*uint8)(data) = test.response
+			return nil
+		})
+		address, status, err := i2c.ReceiveAlert()
+		if err != nil || address != test.address || status != test.status {
+			t.Errorf("ReceiveAlert of 0x%02X = 0x%02X, %d, %v", test.response, address, status, err)
+		}
+		if len(addresses) != 2 || addresses[0] != AlertResponseAddress || addresses[1] != 0x48 || i2c.address != 0x48 {
+			t.Errorf("set addresses %X instead of the ARA and 0x48 again", addresses)
+		}
+	}
+
+	// no device responds
+	fakeSMBus(t, func(readWrite, register uint8, size int, data unsafe.Pointer) error {
+		return syscall.ENXIO
+	})
+	if _, _, err := i2c.ReceiveAlert(); !errors.Is(err, syscall.ENXIO) || !strings.Contains(err.Error(), "I2C.ReceiveAlert") {
+		t.Errorf("ReceiveAlert without response returned %v", err)
+	}
+	if i2c.address != 0x48 {
+		t.Errorf("address is 0x%02X after the failed ReceiveAlert", i2c.address)
+	}
+}
+
+func TestDumpRegistersError(t *testing.T) {
+	fakeSMBus(t, func(readWrite, register uint8, size int, data unsafe.Pointer) error {
+		if register == 0x42 {
+			return syscall.EIO
+		}
+		*(*uint8)(data) = register
+		return nil
+	})
+
+	registers, err := (&I2C{address: 0x20}).DumpRegisters()
+	if !errors.Is(err, syscall.EIO) {
+		t.Fatalf("error %v does not wrap EIO", err)
+	}
+	if !strings.Contains(err.Error(), "register 0x42") {
+		t.Errorf("error %q does not name register 0x42", err)
+	}
+	if registers[0x41] != 0x41 {
+		t.Errorf("register 0x41 is 0x%02X", registers[0x41])
+	}
+}
+
+func TestBlockLengthValidation(t *testing.T) {
+	fakeSMBus(t, func(readWrite, register uint8, size int, data unsafe.Pointer) error {
+		return nil
+	})
+	i2c := &I2C{address: 0x20}
+
+	for _, n := range []int{0, i2c.MaxBlockSize() + 1} {
+		if err := i2c.WriteBlock(0x10, make([]byte, n)); err == nil {
+			t.Errorf("WriteBlock accepted %d bytes", n)
+		}
+		if _, err := i2c.ReadI2CBlock(0x10, n); err == nil {
+			t.Errorf("ReadI2CBlock accepted %d bytes", n)
+		}
+	}
+	for _, n := range []int{1, i2c.MaxBlockSize()} {
+		if err := i2c.WriteBlock(0x10, make([]byte, n)); err != nil {
+			t.Errorf("WriteBlock of %d bytes returned %v", n, err)
+		}
+	}
+}
+
+// constants of linux/i2c.h
+const (
+	smbusWrite = 0 // I2C_SMBUS_WRITE
+	smbusRead  = 1 // I2C_SMBUS_READ
+	smbusQuick = 0 // I2C_SMBUS_QUICK
+	smbusByte  = 1 // I2C_SMBUS_BYTE
+)
+
+// fakeRegisters emulates the byte registers of a device with the bits
+// of readOnly not writable. Accesses to failRegister return EIO
+// while failRead or failWrite are set.
+type fakeRegisters struct {
+	registers    [256]uint8
+	readOnly     uint8
+	failRegister uint8
+	failRead     bool
+	failWrite    bool
+}
+
+func (f *fakeRegisters) access(readWrite, register uint8, size int, data unsafe.Pointer) error {
+	value := (*uint8)(data)
+	if readWrite == smbusRead {
+		if f.failRead && register == f.failRegister {
+			return syscall.EIO
+		}
+		*value = f.registers[register]
+		return nil
+	}
+	if f.failWrite && register == f.failRegister {
+		return syscall.EIO
+	}
+	f.registers[register] = f.registers[register]&f.readOnly | *value&^f.readOnly
+	return nil
+}
+
+func TestUpdateBitsVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		readOnly uint8 // bits of the register that drop writes
+		mask     uint8
+		value    uint8
+		ok       bool
+	}{
+		{"accepted", 0x00, 0x0F, 0x03, true},
+		{"dropped", 0xFF, 0x0F, 0x03, false},
+		{"partly dropped", 0x01, 0x0F, 0x03, false},
+		{"read-only outside of mask", 0xF0, 0x0F, 0x03, true},
+		{"unchanged", 0xFF, 0xF0, 0x50, true},
+	}
+	for _, test := range tests {
+		device := &fakeRegisters{readOnly: test.readOnly}
+		device.registers[0x10] = 0x50
+		fakeSMBus(t, device.access)
+
+		err := (&I2C{address: 0x20}).UpdateBitsVerify(0x10, test.mask, test.value)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: UpdateBitsVerify returned %v", test.name, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "I2C.UpdateBitsVerify") {
+			t.Errorf("%s: error %q is not labeled I2C.UpdateBitsVerify", test.name, err)
+		}
+		// UpdateBits doesn't notice the dropped write
+		if err = (&I2C{address: 0x20}).UpdateBits(0x10, test.mask, test.value); err != nil {
+			t.Errorf("%s: UpdateBits returned %v", test.name, err)
+		}
+	}
+}
+
+func TestProcessCallBlockN(t *testing.T) {
+	for _, lengthByte := range []byte{0x20, 0x01, 0x03} {
+		fakeRDWR(t, func(msgs []i2c_msg) error {
+			if len(msgs) != 2 || msgs[1].flags&M_RD == 0 {
+				t.Fatalf("unexpected messages %+v", msgs)
+			}
+			if tx := msgBytes(msgs[0]); string(tx) != "\x10\x02\xAA\xBB" {
+				t.Errorf("sent % X instead of register, length and block", tx)
+			}
+			rx := msgBytes(msgs[1])
+			rx[0] = lengthByte
+			copy(rx[1:], "\x01\x02\x03")
+			return nil
+		})
+		data, err := (&I2C{address: 0x40}).ProcessCallBlockN(0x10, []byte{0xAA, 0xBB}, 3)
+		if err != nil || string(data) != "\x01\x02\x03" {
+			t.Errorf("ProcessCallBlockN with length byte 0x%02X = % X, %v", lengthByte, data, err)
+		}
+	}
+
+	fakeRDWR(t, func(msgs []i2c_msg) error {
+		t.Fatal("ProcessCallBlockN accessed the device with an invalid length")
+		return nil
+	})
+	i2c := &I2C{address: 0x40}
+	for _, expectLen := range []int{0, i2c.MaxBlockSize() + 1} {
+		if _, err := i2c.ProcessCallBlockN(0x10, []byte{0xAA}, expectLen); err == nil {
+			t.Errorf("ProcessCallBlockN accepted expectLen %d", expectLen)
+		}
+	}
+}
+
+func TestProbeWritable(t *testing.T) {
+	tests := []struct {
+		name     string
+		readOnly uint8
+		mask     uint8
+		writable bool
+	}{
+		{"writable", 0x00, 0xFF, true},
+		{"read-only", 0xFF, 0xFF, false},
+		{"reserved bit", 0x80, 0xFF, false},
+		{"reserved bit masked", 0x80, 0x7F, true},
+		{"single bit", 0xFE, 0x01, true},
+	}
+	for _, test := range tests {
+		device := &fakeRegisters{readOnly: test.readOnly}
+		device.registers[0x10] = 0x5A
+		fakeSMBus(t, device.access)
+
+		writable, err := (&I2C{address: 0x20}).ProbeWritableMask(0x10, test.mask)
+		if err != nil || writable != test.writable {
+			t.Errorf("%s: ProbeWritableMask = %t, %v", test.name, writable, err)
+		}
+		if device.registers[0x10] != 0x5A {
+			t.Errorf("%s: register is 0x%02X instead of the restored 0x5A", test.name, device.registers[0x10])
+		}
+		if test.mask != 0xFF {
+			continue
+		}
+		writable, err = (&I2C{address: 0x20}).ProbeWritable(0x10)
+		if err != nil || writable != test.writable {
+			t.Errorf("%s: ProbeWritable = %t, %v", test.name, writable, err)
+		}
+		if device.registers[0x10] != 0x5A {
+			t.Errorf("%s: register is 0x%02X instead of the restored 0x5A", test.name, device.registers[0x10])
+		}
+	}
+
+	if _, err := (&I2C{address: 0x20}).ProbeWritableMask(0x10, 0); err == nil {
+		t.Error("ProbeWritableMask accepted mask 0")
+	}
+}
+
+func TestProbeWritableRestores(t *testing.T) {
+	device := &fakeRegisters{failRegister: 0x10}
+	device.registers[0x10] = 0x5A
+	fakeSMBus(t, func(readWrite, register uint8, size int, data unsafe.Pointer) error {
+		err := device.access(readWrite, register, size, data)
+		// fail the read back after the inverted value was written
+		device.failRead = readWrite != smbusRead
+		return err
+	})
+
+	writable, err := (&I2C{address: 0x20}).ProbeWritable(0x10)
+	if err == nil || writable {
+		t.Errorf("ProbeWritable = %t, %v with failing read back", writable, err)
+	}
+	if device.registers[0x10] != 0x5A {
+		t.Errorf("register is 0x%02X instead of the restored 0x5A", device.registers[0x10])
+	}
+
+	device = &fakeRegisters{failRegister: 0x10}
+	writes := 0
+	fakeSMBus(t, func(readWrite, register uint8, size int, data unsafe.Pointer) error {
+		if readWrite != smbusRead {
+			writes++
+			// fail the restore
+			device.failWrite = writes == 2
+		}
+		return device.access(readWrite, register, size, data)
+	})
+	writable, err = (&I2C{address: 0x20}).ProbeWritable(0x10)
+	if !errors.Is(err, syscall.EIO) || writable {
+		t.Errorf("ProbeWritable = %t, %v with failing restore", writable, err)
+	}
+}
+
+func TestSignExtend(t *testing.T) {
+	tests := []struct {
+		value  uint32
+		bits   int
+		result int32
+	}{
+		{0x0, 1, 0},
+		{0x1, 1, -1},
+		{0x000, 12, 0},
+		{0x7FF, 12, 2047},
+		{0x800, 12, -2048},
+		{0xFFF, 12, -1},
+		{0xF7FF, 12, 2047}, // bits above the width are ignored
+		{0x7FFFF, 20, 524287},
+		{0x80000, 20, -524288},
+		{0xFFFFF, 20, -1},
+		{0x7FFFFFFF, 32, math.MaxInt32},
+		{0x80000000, 32, math.MinInt32},
+		{0xFFFFFFFF, 32, -1},
+	}
+	for _, test := range tests {
+		if result := SignExtend(test.value, test.bits); result != test.result {
+			t.Errorf("SignExtend(0x%X, %d) = %d instead of %d", test.value, test.bits, result, test.result)
+		}
+	}
+}
+
+func TestReadScaled16(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   [2]byte
+		order  binary.ByteOrder
+		shift  int
+		lsb    float64
+		result float64
+	}{
+		{"TMP102 -25°C", [2]byte{0xE7, 0x00}, binary.BigEndian, 4, 0.0625, -25},
+		{"TMP102 -0.0625°C", [2]byte{0xFF, 0xF0}, binary.BigEndian, 4, 0.0625, -0.0625},
+		{"TMP102 25°C", [2]byte{0x19, 0x00}, binary.BigEndian, 4, 0.0625, 25},
+		{"LM75 -25°C", [2]byte{0xE7, 0x00}, binary.BigEndian, 7, 0.5, -25},
+		{"LM75 -0.5°C", [2]byte{0xFF, 0x80}, binary.BigEndian, 7, 0.5, -0.5},
+		{"little endian", [2]byte{0x00, 0xE7}, binary.LittleEndian, 4, 0.0625, -25},
+	}
+	for _, test := range tests {
+		fakeRDWR(t, func(msgs []i2c_msg) error {
+			copy(msgBytes(msgs[1]), test.data[:])
+			return nil
+		})
+		result, err := (&I2C{address: 0x48}).ReadScaled16(0x00, test.order, test.shift, test.lsb)
+		if err != nil || result != test.result {
+			t.Errorf("%s: ReadScaled16 = %g, %v instead of %g", test.name, result, err, test.result)
+		}
+	}
+
+	fakeRDWR(t, func(msgs []i2c_msg) error {
+		t.Fatal("ReadScaled16 accessed the device with an invalid shift")
+		return nil
+	})
+	for _, shift := range []int{-1, 16} {
+		if _, err := (&I2C{address: 0x48}).ReadScaled16(0x00, binary.BigEndian, shift, 1); err == nil {
+			t.Errorf("ReadScaled16 accepted shift %d", shift)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// Package i2ctest provides a mock implementation of i2c.Bus
+// for testing device drivers without hardware.
+package i2ctest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/SpaceLeap/go-embedded/i2c"
+)
+
+var ErrClosed = errors.New("i2ctest: MockBus is closed")
+
+// Call is a method call recorded by MockBus.
+type Call struct {
+	Method   string
+	Address  int
+	Register uint8
+	// Data written by the call, nil for reads
+	Data []byte
+}
+
+// device is the register memory of one address.
+type device struct {
+	registers [256]uint8
+	blocks    map[uint8][]byte
+	pointer   uint8
+}
+
+// MockBus implements i2c.Bus in memory with 256 byte registers per address.
+// Word and I2C block accesses use consecutive registers, LSB first for words.
+// SMBus blocks with a length byte are programmed with SetBlock.
+// ReadUint8 and WriteUint8 without register use a register pointer
+// that WriteUint8 sets, as many simple devices do.
+// All calls are recorded, see Calls.
+type MockBus struct {
+	mutex   sync.Mutex
+	address int
+	devices map[int]*device
+	calls   []Call
+	err     error
+	closed  bool
+}
+
+var _ i2c.Bus = (*MockBus)(nil)
+
+func NewMockBus(address int) *MockBus {
+	return &MockBus{address: address, devices: make(map[int]*device)}
+}
+
+func (bus *MockBus) device(address int) *device {
+	d, ok := bus.devices[address]
+	if !ok {
+		d = &device{blocks: make(map[uint8][]byte)}
+		bus.devices[address] = d
+	}
+	return d
+}
+
+// SetRegister programs the value of a register at address.
+func (bus *MockBus) SetRegister(address int, register, value uint8) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.device(address).registers[register] = value
+}
+
+// Register returns the value of a register at address,
+// for example to check what a driver wrote.
+func (bus *MockBus) Register(address int, register uint8) uint8 {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.device(address).registers[register]
+}
+
+// SetBlock programs the block returned by ReadBlock for a register at address.
+func (bus *MockBus) SetBlock(address int, register uint8, block []byte) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.device(address).blocks[register] = append([]byte(nil), block...)
+}
+
+// SetErr makes all following calls return err, nil resets it.
+func (bus *MockBus) SetErr(err error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.err = err
+}
+
+// Calls returns all recorded calls.
+func (bus *MockBus) Calls() []Call {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return append([]Call(nil), bus.calls...)
+}
+
+// call records a call and returns the device of the current address,
+// the caller must hold the mutex.
+func (bus *MockBus) call(method string, register uint8, data []byte) (*device, error) {
+	if data != nil {
+		data = append([]byte(nil), data...)
+	}
+	bus.calls = append(bus.calls, Call{method, bus.address, register, data})
+	if bus.closed {
+		return nil, ErrClosed
+	}
+	if bus.err != nil {
+		return nil, bus.err
+	}
+	return bus.device(bus.address), nil
+}
+
+func (bus *MockBus) Address() int {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	return bus.address
+}
+
+func (bus *MockBus) SetAddress(address int) error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	_, err := bus.call("SetAddress", 0, nil)
+	if err != nil {
+		return err
+	}
+	bus.address = address
+	return nil
+}
+
+func (bus *MockBus) ReadUint8() (uint8, error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	d, err := bus.call("ReadUint8", 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	return d.registers[d.pointer], nil
+}
+
+func (bus *MockBus) WriteUint8(value uint8) error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	d, err := bus.call("WriteUint8", 0, []byte{value})
+	if err != nil {
+		return err
+	}
+	d.pointer = value
+	return nil
+}
+
+func (bus *MockBus) ReadUint8Reg(register uint8) (uint8, error) {
+	data, err := bus.read("ReadUint8Reg", register, 1)
+	if err != nil {
+		return 0, err
+	}
+	return data[0], nil
+}
+
+func (bus *MockBus) WriteUint8Reg(register uint8, value uint8) error {
+	return bus.write("WriteUint8Reg", register, []byte{value})
+}
+
+func (bus *MockBus) ReadUint16Reg(register uint8) (uint16, error) {
+	data, err := bus.read("ReadUint16Reg", register, 2)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(data[0]) | uint16(data[1])<<8, nil
+}
+
+func (bus *MockBus) WriteUint16Reg(register uint8, value uint16) error {
+	return bus.write("WriteUint16Reg", register, []byte{byte(value), byte(value >> 8)})
+}
+
+func (bus *MockBus) ReadBlock(register uint8) ([]byte, error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	d, err := bus.call("ReadBlock", register, nil)
+	if err != nil {
+		return nil, err
+	}
+	block, ok := d.blocks[register]
+	if !ok {
+		return nil, fmt.Errorf("i2ctest: no block set for register 0x%02X at address 0x%02X", register, bus.address)
+	}
+	return append([]byte(nil), block...), nil
+}
+
+func (bus *MockBus) WriteBlock(register uint8, block []byte) error {
+	if !i2c.ValidBlockLength(len(block)) {
+		return fmt.Errorf("i2ctest: invalid block length %d", len(block))
+	}
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	d, err := bus.call("WriteBlock", register, block)
+	if err != nil {
+		return err
+	}
+	d.blocks[register] = append([]byte(nil), block...)
+	return nil
+}
+
+func (bus *MockBus) ReadI2CBlock(register uint8, length int) ([]byte, error) {
+	if !i2c.ValidBlockLength(length) {
+		return nil, fmt.Errorf("i2ctest: invalid block length %d", length)
+	}
+	return bus.read("ReadI2CBlock", register, length)
+}
+
+func (bus *MockBus) WriteI2CBlock(register uint8, block []byte) error {
+	if !i2c.ValidBlockLength(len(block)) {
+		return fmt.Errorf("i2ctest: invalid block length %d", len(block))
+	}
+	return bus.write("WriteI2CBlock", register, block)
+}
+
+// read returns n consecutive registers starting at register,
+// wrapping around after register 0xFF.
+func (bus *MockBus) read(method string, register uint8, n int) ([]byte, error) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	d, err := bus.call(method, register, nil)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = d.registers[register+uint8(i)]
+	}
+	return data, nil
+}
+
+// write sets consecutive registers starting at register,
+// wrapping around after register 0xFF.
+func (bus *MockBus) write(method string, register uint8, data []byte) error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	d, err := bus.call(method, register, data)
+	if err != nil {
+		return err
+	}
+	for i, b := range data {
+		d.registers[register+uint8(i)] = b
+	}
+	return nil
+}
+
+// Close makes all following calls fail with ErrClosed.
+func (bus *MockBus) Close() error {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.closed = true
+	return nil
+}
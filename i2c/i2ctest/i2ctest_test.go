@@ -0,0 +1,140 @@
+package i2ctest
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegisters(t *testing.T) {
+	bus := NewMockBus(0x48)
+	bus.SetRegister(0x48, 0x01, 0x60)
+	if value, err := bus.ReadUint8Reg(0x01); err != nil || value != 0x60 {
+		t.Errorf("ReadUint8Reg = 0x%02X, %v", value, err)
+	}
+	if err := bus.WriteUint8Reg(0x02, 0x4B); err != nil {
+		t.Fatal(err)
+	}
+	if value := bus.Register(0x48, 0x02); value != 0x4B {
+		t.Errorf("register 0x02 is 0x%02X after WriteUint8Reg", value)
+	}
+
+	// the register pointer is set by WriteUint8
+	if err := bus.WriteUint8(0x01); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := bus.ReadUint8(); err != nil || value != 0x60 {
+		t.Errorf("ReadUint8 = 0x%02X, %v", value, err)
+	}
+}
+
+func TestWords(t *testing.T) {
+	bus := NewMockBus(0x48)
+	if err := bus.WriteUint16Reg(0x10, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if low, high := bus.Register(0x48, 0x10), bus.Register(0x48, 0x11); low != 0x34 || high != 0x12 {
+		t.Errorf("registers are 0x%02X 0x%02X instead of LSB first", low, high)
+	}
+	if value, err := bus.ReadUint16Reg(0x10); err != nil || value != 0x1234 {
+		t.Errorf("ReadUint16Reg = 0x%04X, %v", value, err)
+	}
+
+	// consecutive registers wrap around after 0xFF
+	if err := bus.WriteUint16Reg(0xFF, 0xABCD); err != nil {
+		t.Fatal(err)
+	}
+	if low, high := bus.Register(0x48, 0xFF), bus.Register(0x48, 0x00); low != 0xCD || high != 0xAB {
+		t.Errorf("registers are 0x%02X 0x%02X after wrap around", low, high)
+	}
+}
+
+func TestBlocks(t *testing.T) {
+	bus := NewMockBus(0x50)
+	if err := bus.WriteI2CBlock(0x20, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := bus.ReadI2CBlock(0x21, 2); err != nil || string(data) != "\x02\x03" {
+		t.Errorf("ReadI2CBlock = % X, %v", data, err)
+	}
+	if _, err := bus.ReadI2CBlock(0x20, 0); err == nil {
+		t.Error("ReadI2CBlock accepted length 0")
+	}
+	if err := bus.WriteI2CBlock(0x20, make([]byte, 33)); err == nil {
+		t.Error("WriteI2CBlock accepted 33 bytes")
+	}
+
+	if _, err := bus.ReadBlock(0x30); err == nil {
+		t.Error("ReadBlock returned a block that was not set")
+	}
+	bus.SetBlock(0x50, 0x30, []byte("ABC"))
+	if data, err := bus.ReadBlock(0x30); err != nil || string(data) != "ABC" {
+		t.Errorf("ReadBlock = %q, %v", data, err)
+	}
+	if err := bus.WriteBlock(0x31, []byte("DE")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := bus.ReadBlock(0x31); err != nil || string(data) != "DE" {
+		t.Errorf("ReadBlock after WriteBlock = %q, %v", data, err)
+	}
+}
+
+func TestSetAddress(t *testing.T) {
+	bus := NewMockBus(0x48)
+	bus.SetRegister(0x48, 0x00, 0x11)
+	bus.SetRegister(0x49, 0x00, 0x22)
+	if err := bus.SetAddress(0x49); err != nil {
+		t.Fatal(err)
+	}
+	if bus.Address() != 0x49 {
+		t.Errorf("Address is 0x%02X", bus.Address())
+	}
+	if value, err := bus.ReadUint8Reg(0x00); err != nil || value != 0x22 {
+		t.Errorf("ReadUint8Reg after SetAddress = 0x%02X, %v", value, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	bus := NewMockBus(0x48)
+	errNack := errors.New("nack")
+	bus.SetErr(errNack)
+	if _, err := bus.ReadUint8Reg(0x00); err != errNack {
+		t.Errorf("ReadUint8Reg returned %v instead of the set error", err)
+	}
+	bus.SetErr(nil)
+	if _, err := bus.ReadUint8Reg(0x00); err != nil {
+		t.Errorf("ReadUint8Reg returned %v after resetting the error", err)
+	}
+
+	if err := bus.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bus.WriteUint8Reg(0x00, 1); err != ErrClosed {
+		t.Errorf("WriteUint8Reg returned %v after Close", err)
+	}
+}
+
+func TestCalls(t *testing.T) {
+	bus := NewMockBus(0x48)
+	bus.WriteUint8Reg(0x01, 0x60)
+	bus.ReadUint16Reg(0x00)
+	bus.SetAddress(0x49)
+	bus.WriteI2CBlock(0x02, []byte{1, 2})
+
+	expected := []Call{
+		{"WriteUint8Reg", 0x48, 0x01, []byte{0x60}},
+		{"ReadUint16Reg", 0x48, 0x00, nil},
+		{"SetAddress", 0x48, 0x00, nil},
+		{"WriteI2CBlock", 0x49, 0x02, []byte{1, 2}},
+	}
+	calls := bus.Calls()
+	if len(calls) != len(expected) {
+		t.Fatalf("Calls = %+v", calls)
+	}
+	for i, call := range calls {
+		e := expected[i]
+		if call.Method != e.Method || call.Address != e.Address || call.Register != e.Register ||
+			string(call.Data) != string(e.Data) || (call.Data == nil) != (e.Data == nil) {
+			t.Errorf("call %d is %+v instead of %+v", i, call, e)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package i2c
+
+import "fmt"
+
+// Mux is a TCA9548A/PCA9548A style I2C multiplexer with up to 8 channels,
+// that are selected by writing a bitmask with one bit per channel
+// to the control register of the multiplexer.
+//
+// The multiplexer shares the I2C handle with the downstream devices,
+// its address is only set temporarily for the channel selection,
+// so the handle keeps pointing at the downstream device.
+type Mux struct {
+	i2c     *I2C
+	address int
+}
+
+func NewMux(i2c *I2C, address int) *Mux {
+	return &Mux{i2c: i2c, address: address}
+}
+
+func (mux *Mux) Address() int {
+	return mux.address
+}
+
+// SelectChannel connects the downstream channel n from 0 to 7
+// and disconnects all others.
+func (mux *Mux) SelectChannel(n int) error {
+	if n < 0 || n > 7 {
+		return wrapErr("Mux.SelectChannel", fmt.Errorf("Channel is %d, but must be in the range 0 to 7", n))
+	}
+	return wrapErr("Mux.SelectChannel", mux.write(1<<uint(n)))
+}
+
+// Deselect disconnects all downstream channels.
+func (mux *Mux) Deselect() error {
+	return wrapErr("Mux.Deselect", mux.write(0))
+}
+
+func (mux *Mux) write(mask uint8) error {
+	return mux.i2c.withAddress(mux.address, func() error {
+		return mux.i2c.WriteUint8(mask)
+	})
+}
@@ -0,0 +1,86 @@
+package i2c
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/SpaceLeap/go-embedded/gpio"
+)
+
+// recoverHalfPeriod is the half clock period of the recovery sequence,
+// time.Sleep makes the actual clock slower than 100kHz.
+const recoverHalfPeriod = 5 * time.Microsecond
+
+// RecoverBus frees a bus with SDA held low by a device that got stuck
+// in the middle of a transfer. It exports SCL and SDA as GPIOs,
+// clocks up to 9 pulses on SCL until the device releases SDA,
+// issues a STOP condition and unexports the GPIOs again.
+// The lines are only driven low and released as inputs otherwise,
+// like open drain outputs, relying on the pull-up resistors of the bus.
+//
+// The pins must be muxed to GPIO mode while recovering and back
+// to I2C mode afterwards, which is up to the caller, for example
+// with a device tree overlay. The bus must not be used meanwhile.
+func RecoverBus(sclPin, sdaPin int) (err error) {
+	scl, err := gpio.NewGPIO(sclPin, gpio.DIRECTION_IN)
+	if err != nil {
+		return wrapErr("RecoverBus", err)
+	}
+	defer func() { err = errors.Join(err, wrapErr("RecoverBus", scl.Close())) }()
+	sda, err := gpio.NewGPIO(sdaPin, gpio.DIRECTION_IN)
+	if err != nil {
+		return wrapErr("RecoverBus", err)
+	}
+	defer func() { err = errors.Join(err, wrapErr("RecoverBus", sda.Close())) }()
+
+	for i := 0; i < 9; i++ {
+		value, err := sda.Value()
+		if err != nil {
+			return wrapErr("RecoverBus", err)
+		}
+		if value == gpio.HIGH {
+			break
+		}
+		if err = clockPulse(scl); err != nil {
+			return wrapErr("RecoverBus", err)
+		}
+	}
+
+	// STOP: SDA rises while SCL is high
+	steps := []func() error{
+		func() error { return scl.SetDirectionOut(gpio.LOW) },
+		func() error { return sda.SetDirectionOut(gpio.LOW) },
+		func() error { return scl.SetDirection(gpio.DIRECTION_IN) },
+		func() error { return sda.SetDirection(gpio.DIRECTION_IN) },
+	}
+	for _, step := range steps {
+		if err = step(); err != nil {
+			return wrapErr("RecoverBus", err)
+		}
+		time.Sleep(recoverHalfPeriod)
+	}
+
+	value, err := sda.Value()
+	if err != nil {
+		return wrapErr("RecoverBus", err)
+	}
+	if value != gpio.HIGH {
+		return wrapErr("RecoverBus", fmt.Errorf("SDA still held low after recovery"))
+	}
+	return nil
+}
+
+func clockPulse(scl *gpio.GPIO) error {
+	err := scl.SetDirectionOut(gpio.LOW)
+	if err != nil {
+		return err
+	}
+	time.Sleep(recoverHalfPeriod)
+	err = scl.SetDirection(gpio.DIRECTION_IN)
+	if err != nil {
+		return err
+	}
+	time.Sleep(recoverHalfPeriod)
+	return nil
+}
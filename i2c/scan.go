@@ -0,0 +1,31 @@
+package i2c
+
+import "syscall"
+
+// Scan returns the addresses from 0x03 to 0x77 on bus where a device
+// responds to the probe of Present, like i2cdetect.
+// Addresses where a kernel driver is bound are skipped,
+// as i2cdetect shows them as "UU" without probing.
+// Scan uses its own handle of the bus and closes it afterwards.
+func Scan(bus int) ([]int, error) {
+	i2c, err := NewI2C(bus, 0x03)
+	if err != nil {
+		return nil, err
+	}
+	defer i2c.Close()
+
+	var addresses []int
+	for address := 0x03; address <= 0x77; address++ {
+		err = i2c.SetAddress(address)
+		if e, ok := err.(Err); ok && e.cause == syscall.EBUSY {
+			continue
+		}
+		if err != nil {
+			return nil, wrapErr("Scan", err)
+		}
+		if i2c.Present() {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
@@ -0,0 +1,76 @@
+package i2c
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/SpaceLeap/go-embedded"
+)
+
+// ErrBusSpeedNotSupported is returned by SetBusSpeed
+// if the adapter has no BusSpeedAttribute.
+var ErrBusSpeedNotSupported = errors.New("I2C bus speed can't be changed at runtime")
+
+var adapterClassPath = "/sys/class/i2c-adapter"
+
+// BusSpeedAttribute is the name of the sysfs attribute of an adapter
+// with its clock frequency in Hz. The mainline kernel controllers don't
+// offer it, but vendor kernels like the one of NVIDIA Jetson boards do.
+var BusSpeedAttribute = "bus_clk_rate"
+
+func busSpeedPath(bus int) string {
+	return fmt.Sprintf("%s/i2c-%d/%s", adapterClassPath, bus, BusSpeedAttribute)
+}
+
+// BusSpeed returns the clock frequency in Hz of bus.
+// It is read from the BusSpeedAttribute of the adapter if it exists,
+// otherwise from the clock-frequency property of the device tree node
+// of the adapter, which most device tree based controllers like the OMAP I2C
+// of the BeagleBone use. Adapters without both return an error.
+func BusSpeed(bus int) (int, error) {
+	hz, err := embedded.ReadSysfsInt(busSpeedPath(bus))
+	if err == nil {
+		return hz, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return 0, wrapErr("BusSpeed", err)
+	}
+
+	filename := fmt.Sprintf("%s/i2c-%d/of_node/clock-frequency", adapterClassPath, bus)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, wrapErr("BusSpeed", err)
+	}
+	if len(data) != 4 {
+		return 0, wrapErr("BusSpeed", fmt.Errorf("%s has %d bytes instead of a 32 bit value", filename, len(data)))
+	}
+	// device tree properties are big endian
+	return int(binary.BigEndian.Uint32(data)), nil
+}
+
+// SetBusSpeed sets the clock frequency of bus in Hz
+// by writing the BusSpeedAttribute of the adapter.
+// If the adapter has no such attribute, ErrBusSpeedNotSupported is returned
+// and the frequency has to be set with the clock-frequency property
+// in the device tree, for example with an overlay.
+func SetBusSpeed(bus int, hz int) error {
+	if hz <= 0 {
+		return wrapErr("SetBusSpeed", fmt.Errorf("Bus speed is %d Hz, but must be positive", hz))
+	}
+	// don't create the attribute if it doesn't exist
+	file, err := os.OpenFile(busSpeedPath(bus), os.O_WRONLY, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return wrapErr("SetBusSpeed", ErrBusSpeedNotSupported)
+	}
+	if err != nil {
+		return wrapErr("SetBusSpeed", err)
+	}
+	err = embedded.WriteSysfsFileInt64(file, int64(hz))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return wrapErr("SetBusSpeed", err)
+}
@@ -0,0 +1,77 @@
+package i2c
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeAdapter creates the directory of adapter i2c-1 in a temporary
+// adapter class path and the given files in it.
+func fakeAdapter(t *testing.T, files map[string][]byte) string {
+	saved := adapterClassPath
+	adapterClassPath = t.TempDir()
+	t.Cleanup(func() { adapterClassPath = saved })
+
+	dir := filepath.Join(adapterClassPath, "i2c-1")
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content, 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBusSpeed(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string][]byte
+		hz    int
+		ok    bool
+	}{
+		{"device tree", map[string][]byte{"of_node/clock-frequency": {0x00, 0x06, 0x1A, 0x80}}, 400000, true},
+		{"wrong length", map[string][]byte{"of_node/clock-frequency": {0x06, 0x1A, 0x80}}, 0, false},
+		{"missing", nil, 0, false},
+		{"attribute", map[string][]byte{
+			"bus_clk_rate":            []byte("100000\n"),
+			"of_node/clock-frequency": {0x00, 0x06, 0x1A, 0x80},
+		}, 100000, true},
+		{"invalid attribute", map[string][]byte{"bus_clk_rate": []byte("fast\n")}, 0, false},
+	}
+	for _, test := range tests {
+		fakeAdapter(t, test.files)
+		hz, err := BusSpeed(1)
+		if (err == nil) != test.ok || hz != test.hz {
+			t.Errorf("%s: BusSpeed = %d, %v", test.name, hz, err)
+		}
+	}
+}
+
+func TestSetBusSpeed(t *testing.T) {
+	dir := fakeAdapter(t, nil)
+	err := SetBusSpeed(1, 400000)
+	if !errors.Is(err, ErrBusSpeedNotSupported) {
+		t.Errorf("SetBusSpeed without attribute returned %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "bus_clk_rate")); !os.IsNotExist(err) {
+		t.Errorf("SetBusSpeed created the attribute")
+	}
+
+	fakeAdapter(t, map[string][]byte{"bus_clk_rate": []byte("100000\n")})
+	if err = SetBusSpeed(1, 400000); err != nil {
+		t.Fatal(err)
+	}
+	if hz, err := BusSpeed(1); err != nil || hz != 400000 {
+		t.Errorf("BusSpeed after SetBusSpeed = %d, %v", hz, err)
+	}
+	for _, hz := range []int{0, -1} {
+		if err = SetBusSpeed(1, hz); err == nil {
+			t.Errorf("SetBusSpeed accepted %d Hz", hz)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package i2c
+
+import (
+	"context"
+	"time"
+)
+
+// WatchDevice polls every interval if a device is present at address
+// on bus, see Present, and sends the initial state and every change
+// of it to the returned channel until ctx is done.
+// It uses its own handle of the bus, so the address of other
+// handles is not changed.
+// The handle is closed and the channel closed when ctx is done.
+func WatchDevice(ctx context.Context, bus, address int, interval time.Duration) (<-chan bool, error) {
+	i2c, err := NewI2C(bus, address)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(chan bool)
+	go func() {
+		defer close(changes)
+		defer i2c.Close()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		send := func(present bool) bool {
+			select {
+			case changes <- present:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		present := i2c.Present()
+		if !send(present) {
+			return
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if p := i2c.Present(); p != present {
+				present = p
+				if !send(present) {
+					return
+				}
+			}
+		}
+	}()
+	return changes, nil
+}
@@ -0,0 +1,122 @@
+package pwm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// EffectStep is the interval in which effects like Breathe update the duty.
+var EffectStep = 20 * time.Millisecond
+
+// now returns the time of the effects. It is a variable so that
+// tests can step the time independent of the ticker.
+var now = time.Now
+
+// SineWave is a waveform for Breathe that rises from 0 at phase 0
+// to 1 at phase 0.5 and falls back to 0 at phase 1 along a sine.
+func SineWave(phase float64) float64 {
+	return (1 - math.Cos(2*math.Pi*phase)) / 2
+}
+
+// GammaSineWave is SineWave with a gamma correction of 2.2
+// for a perceptually linear change of LED brightness.
+func GammaSineWave(phase float64) float64 {
+	return math.Pow(SineWave(phase), 2.2)
+}
+
+// Breathe modulates the duty of the PWM with a "breathing" effect
+// until ctx is done and then sets the duty to zero.
+// waveform maps the phase within period from 0 to 1
+// to a duty fraction of the PWM period from 0 to 1.
+// If waveform is nil, GammaSineWave is used.
+func (pwm *PWM) Breathe(ctx context.Context, period time.Duration, waveform func(phase float64) float64) error {
+	if period <= 0 {
+		return fmt.Errorf("PWM breathe period %s must be positive", period)
+	}
+	if waveform == nil {
+		waveform = GammaSineWave
+	}
+
+	ticker := time.NewTicker(EffectStep)
+	defer ticker.Stop()
+
+	start := now()
+	for {
+		phase := float64(now().Sub(start)%period) / float64(period)
+		err := pwm.SetDuty(time.Duration(waveform(phase) * float64(pwm.period)))
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return pwm.SetDuty(0)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep selects how SweepFrequency steps the frequency.
+type Sweep int
+
+const (
+	SWEEP_LINEAR Sweep = 0
+	SWEEP_LOG    Sweep = 1
+)
+
+// SweepFrequency steps the frequency of the PWM from startHz to endHz
+// over the duration every EffectStep, linear or logarithmic,
+// for example to characterize the frequency response of a driven system.
+// The duty fraction of the period at the start is held constant.
+// If ctx is done before the end frequency was reached,
+// the sweep stops at the current frequency and ctx.Err() is returned.
+func (pwm *PWM) SweepFrequency(ctx context.Context, startHz, endHz float64, over time.Duration, sweep Sweep) error {
+	if startHz <= 0 || endHz <= 0 {
+		return fmt.Errorf("PWM sweep frequencies %g Hz and %g Hz must be positive", startHz, endHz)
+	}
+	if over <= 0 {
+		return fmt.Errorf("PWM sweep duration %s must be positive", over)
+	}
+	fraction := 0.0
+	if pwm.period > 0 {
+		fraction = float64(pwm.duty) / float64(pwm.period)
+	}
+
+	ticker := time.NewTicker(EffectStep)
+	defer ticker.Stop()
+
+	start := now()
+	for {
+		progress := math.Min(float64(now().Sub(start))/float64(over), 1)
+		hz := startHz + (endHz-startHz)*progress
+		if sweep == SWEEP_LOG {
+			hz = startHz * math.Pow(endHz/startHz, progress)
+		}
+		err := pwm.setPeriodKeepingFraction(time.Duration(float64(time.Second)/hz), fraction)
+		if err != nil || progress == 1 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// setPeriodKeepingFraction sets period and the duty as fraction of it
+// in the order that never lets the duty exceed the period.
+func (pwm *PWM) setPeriodKeepingFraction(period time.Duration, fraction float64) error {
+	duty := time.Duration(fraction * float64(period))
+	if period < pwm.period {
+		if err := pwm.SetDuty(duty); err != nil {
+			return err
+		}
+		return pwm.SetPeriod(period)
+	}
+	if err := pwm.SetPeriod(period); err != nil {
+		return err
+	}
+	return pwm.SetDuty(duty)
+}
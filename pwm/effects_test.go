@@ -0,0 +1,121 @@
+package pwm
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sweepWrite is a write of the period or duty by SweepFrequency.
+type sweepWrite struct {
+	name  string
+	value int64
+}
+
+// recordSweep returns a PWM of a fake chip with a period of 10ms (100Hz)
+// and a quarter duty, records its period and duty writes
+// and steps the time by a quarter of over after every period write.
+func recordSweep(t *testing.T, over time.Duration) (*PWM, *[]sweepWrite) {
+	fakeChip(t, 0)
+	pwm, err := NewPWMChip(0, 0, 10*time.Millisecond, 2500*time.Microsecond, POLARITY_LOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pwm.Close() })
+
+	savedStep, savedNow, savedWrite := EffectStep, now, writeFileInt64
+	t.Cleanup(func() { EffectStep, now, writeFileInt64 = savedStep, savedNow, savedWrite })
+	EffectStep = time.Millisecond
+	start := time.Now()
+	var elapsed time.Duration
+	now = func() time.Time { return start.Add(elapsed) }
+	writes := new([]sweepWrite)
+	writeFileInt64 = func(file *os.File, value int64) error {
+		name := filepath.Base(file.Name())
+		*writes = append(*writes, sweepWrite{name, value})
+		if name == "period" {
+			elapsed += over / 4
+		}
+		return savedWrite(file, value)
+	}
+	return pwm, writes
+}
+
+func TestSweepFrequency(t *testing.T) {
+	tests := []struct {
+		name           string
+		startHz, endHz float64
+		sweep          Sweep
+		hz             []float64
+	}{
+		{"linear up", 100, 1000, SWEEP_LINEAR, []float64{100, 325, 550, 775, 1000}},
+		{"log up", 100, 1000, SWEEP_LOG, []float64{100, 177.8279, 316.2278, 562.3413, 1000}},
+		{"linear down", 100, 20, SWEEP_LINEAR, []float64{100, 80, 60, 40, 20}},
+		{"log down", 100, 25, SWEEP_LOG, []float64{100, 70.71068, 50, 35.35534, 25}},
+	}
+	for _, test := range tests {
+		over := time.Second
+		pwm, writes := recordSweep(t, over)
+
+		err := pwm.SweepFrequency(context.Background(), test.startHz, test.endHz, over, test.sweep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*writes) != 2*len(test.hz) {
+			t.Fatalf("%s: %d writes instead of %d: %v", test.name, len(*writes), 2*len(test.hz), *writes)
+		}
+		period, duty := int64(10*time.Millisecond), int64(2500*time.Microsecond)
+		for i, hz := range test.hz {
+			first, second := (*writes)[2*i], (*writes)[2*i+1]
+			newPeriod := int64(float64(time.Second) / hz)
+			// the duty never exceeds the period between the writes
+			if newPeriod < period && (first.name != "duty_cycle" || second.name != "period") {
+				t.Errorf("%s step %d: shrinking period written as %v", test.name, i, []sweepWrite{first, second})
+			}
+			if newPeriod >= period && (first.name != "period" || second.name != "duty_cycle") {
+				t.Errorf("%s step %d: growing period written as %v", test.name, i, []sweepWrite{first, second})
+			}
+			for _, w := range []sweepWrite{first, second} {
+				if w.name == "period" {
+					period = w.value
+				} else {
+					duty = w.value
+				}
+			}
+			if math.Abs(float64(time.Second)/float64(period)-hz) > hz*1e-6 {
+				t.Errorf("%s step %d: %g Hz instead of %g Hz", test.name, i, float64(time.Second)/float64(period), hz)
+			}
+			// the duty fraction of the start is held
+			if d := duty - period/4; d < -1 || d > 1 {
+				t.Errorf("%s step %d: duty %d of period %d is not a quarter", test.name, i, duty, period)
+			}
+		}
+		if pwm.Period() != time.Duration(float64(time.Second)/test.endHz) {
+			t.Errorf("%s: sweep ended at the period %s", test.name, pwm.Period())
+		}
+	}
+}
+
+func TestSweepFrequencyCanceled(t *testing.T) {
+	pwm, writes := recordSweep(t, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := pwm.SweepFrequency(ctx, 100, 1000, time.Second, SWEEP_LINEAR); err != context.Canceled {
+		t.Errorf("SweepFrequency returned %v instead of context.Canceled", err)
+	}
+	if len(*writes) > 4 || pwm.Period() == time.Millisecond {
+		t.Errorf("canceled sweep wrote %v", *writes)
+	}
+
+	for _, hz := range [][2]float64{{0, 100}, {100, -1}} {
+		if err := pwm.SweepFrequency(context.Background(), hz[0], hz[1], time.Second, SWEEP_LOG); err == nil {
+			t.Errorf("SweepFrequency accepted %g Hz to %g Hz", hz[0], hz[1])
+		}
+	}
+	if err := pwm.SweepFrequency(context.Background(), 100, 1000, 0, SWEEP_LINEAR); err == nil {
+		t.Error("SweepFrequency accepted a duration of 0")
+	}
+}
@@ -1,6 +1,8 @@
 package pwm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"time"
@@ -20,11 +22,25 @@ type PWM struct {
 	period       time.Duration
 	duty         time.Duration
 	polarity     Polarity
+	periodPath   string
+	dutyPath     string
+	polarityPath string
 	periodFile   *os.File
 	dutyFile     *os.File
 	polarityFile *os.File
+	enableFile   *os.File // only for the pwmchip layout
+	enabled      bool
+	chipPath     string // empty for the pwm_test layout
+	channel      int
+	minPeriod    uint64
+	maxPeriod    uint64
+	writeOnly    map[string]bool // paths of files opened write-only
 }
 
+// ErrPeriodRangeUnknown is returned by PeriodRange
+// if the valid period range of a PWM is not known.
+var ErrPeriodRangeUnknown = errors.New("PWM period range unknown")
+
 var (
 	deviceTree   string
 	devicePrefix string
@@ -40,6 +56,14 @@ func Init(deviceTreePrefix, pwmDevicePrefix string) error {
 	return nil
 }
 
+var classPath = "/sys/class/pwm"
+
+// SetClassPath overrides the default sysfs PWM class path /sys/class/pwm
+// used by NewPWMChip for all PWMs created afterwards.
+func SetClassPath(pwmClassPath string) {
+	classPath = pwmClassPath
+}
+
 func Cleanup() error {
 	return embedded.UnloadDeviceTree(deviceTree)
 }
@@ -50,13 +74,15 @@ func NewPWM(key string, period, duty time.Duration, polarity Polarity) (*PWM, er
 		return nil, err
 	}
 
-	ocpDir, err := embedded.BuildPath("/sys/devices", "ocp")
+	ocpDir, err := embedded.BuildPath(embedded.SysDevicesBase, "ocp")
 	if err != nil {
 		return nil, err
 	}
 
 	//finds and builds the pwmTestPath, as it can be variable...
-	pwmTestPath, err := embedded.BuildPath(ocpDir, "pwm_test_"+key)
+	ctx, cancel := context.WithTimeout(context.Background(), embedded.DeviceTreeTimeout)
+	defer cancel()
+	pwmTestPath, err := embedded.WaitForPathGlob(ctx, ocpDir, "pwm_test_"+key)
 	if err != nil {
 		return nil, err
 	}
@@ -66,29 +92,28 @@ func NewPWM(key string, period, duty time.Duration, polarity Polarity) (*PWM, er
 	dutyPath := pwmTestPath + "/duty"
 	polarityPath := pwmTestPath + "/polarity"
 
-	periodFile, err := os.OpenFile(periodPath, os.O_RDWR, 0660)
+	pwm := &PWM{
+		key:          key,
+		periodPath:   periodPath,
+		dutyPath:     dutyPath,
+		polarityPath: polarityPath,
+	}
+	pwm.periodFile, err = pwm.openAttribute(periodPath)
 	if err != nil {
 		return nil, err
 	}
-	dutyFile, err := os.OpenFile(dutyPath, os.O_RDWR, 0660)
+	pwm.dutyFile, err = pwm.openAttribute(dutyPath)
 	if err != nil {
-		periodFile.Close()
+		pwm.periodFile.Close()
 		return nil, err
 	}
-	polarityFile, err := os.OpenFile(polarityPath, os.O_RDWR, 0660)
+	pwm.polarityFile, err = pwm.openAttribute(polarityPath)
 	if err != nil {
-		periodFile.Close()
-		dutyFile.Close()
+		pwm.periodFile.Close()
+		pwm.dutyFile.Close()
 		return nil, err
 	}
 
-	pwm := &PWM{
-		key:          key,
-		periodFile:   periodFile,
-		dutyFile:     dutyFile,
-		polarityFile: polarityFile,
-	}
-
 	err = pwm.SetPolarity(polarity)
 	if err != nil {
 		pwm.Close()
@@ -108,24 +133,201 @@ func NewPWM(key string, period, duty time.Duration, polarity Polarity) (*PWM, er
 	return pwm, nil
 }
 
+// NewPWMChip returns a PWM for channel of the PWM chip number chip
+// using the pwmchipN/pwmM layout of newer kernels in the class path
+// /sys/class/pwm, see SetClassPath,
+// instead of the pwm_test_* devices of the capemgr.
+// The channel is exported if necessary and enabled.
+// Close disables and unexports the channel.
+func NewPWMChip(chip, channel int, period, duty time.Duration, polarity Polarity) (*PWM, error) {
+	chipPath := fmt.Sprintf("%s/pwmchip%d", classPath, chip)
+	channelPath := fmt.Sprintf("%s/pwm%d", chipPath, channel)
+
+	if _, err := os.Stat(channelPath); os.IsNotExist(err) {
+		err = embedded.WriteSysfsInt(chipPath+"/export", channel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), embedded.DeviceTreeTimeout)
+	defer cancel()
+	err := embedded.WaitForPath(ctx, channelPath+"/enable")
+	if err != nil {
+		return nil, err
+	}
+
+	pwm := &PWM{
+		key:          fmt.Sprintf("pwmchip%d/pwm%d", chip, channel),
+		periodPath:   channelPath + "/period",
+		dutyPath:     channelPath + "/duty_cycle",
+		polarityPath: channelPath + "/polarity",
+		chipPath:     chipPath,
+		channel:      channel,
+	}
+	files := []struct {
+		file **os.File
+		path string
+	}{
+		{&pwm.periodFile, pwm.periodPath},
+		{&pwm.dutyFile, pwm.dutyPath},
+		{&pwm.polarityFile, pwm.polarityPath},
+		{&pwm.enableFile, channelPath + "/enable"},
+	}
+	for _, f := range files {
+		*f.file, err = pwm.openAttribute(f.path)
+		if err != nil {
+			pwm.Close()
+			return nil, err
+		}
+	}
+
+	// The polarity can only be changed while disabled,
+	// and the duty must never be greater than the period
+	err = pwm.SetEnabled(false)
+	if err == nil {
+		err = pwm.SetPolarity(polarity)
+	}
+	if err == nil {
+		err = pwm.SetDuty(0)
+	}
+	if err == nil {
+		err = pwm.SetPeriod(period)
+	}
+	if err == nil {
+		err = pwm.SetDuty(duty)
+	}
+	if err == nil {
+		err = pwm.SetEnabled(true)
+	}
+	if err != nil {
+		pwm.Close()
+		return nil, err
+	}
+
+	return pwm, nil
+}
+
+// Close closes the files of the PWM and unexports the channel
+// or unloads the device tree overlay.
+// It continues after failures and returns all errors joined.
 func (pwm *PWM) Close() error {
-	pwm.periodFile.Close()
-	pwm.dutyFile.Close()
-	pwm.polarityFile.Close()
+	var errs []error
+	for _, file := range []*os.File{pwm.periodFile, pwm.dutyFile, pwm.polarityFile} {
+		if file != nil {
+			errs = append(errs, file.Close())
+		}
+	}
 
-	return embedded.UnloadDeviceTree(devicePrefix + pwm.key)
+	if pwm.chipPath != "" {
+		if pwm.enableFile != nil {
+			errs = append(errs, pwm.SetEnabled(false), pwm.enableFile.Close())
+		}
+		errs = append(errs, embedded.WriteSysfsInt(pwm.chipPath+"/unexport", pwm.channel))
+	} else {
+		errs = append(errs, embedded.UnloadDeviceTree(devicePrefix+pwm.key))
+	}
+	return errors.Join(errs...)
 }
 
 func (pwm *PWM) Key() string {
 	return pwm.key
 }
 
+// Paths returns the sysfs paths of the period, duty and polarity files
+// that were resolved for the PWM.
+// Errors of the setters contain the path of the failed file.
+func (pwm *PWM) Paths() (period, duty, polarity string) {
+	return pwm.periodPath, pwm.dutyPath, pwm.polarityPath
+}
+
+// openFile opens the sysfs attribute files. It is a variable so that
+// tests can deny read access, which file permissions don't do for root.
+var openFile = os.OpenFile
+
+// writeFileInt64 writes the integer attributes. It is a variable so that
+// tests can record the order of the writes.
+var writeFileInt64 = embedded.WriteSysfsFileInt64
+
+// openAttribute opens a sysfs attribute file read-write,
+// or write-only if reading is not permitted, because
+// the files are mostly written and only read for diagnostics.
+func (pwm *PWM) openAttribute(path string) (*os.File, error) {
+	file, err := openFile(path, os.O_RDWR, 0660)
+	if os.IsPermission(err) {
+		file, err = openFile(path, os.O_WRONLY, 0660)
+		if err == nil {
+			if pwm.writeOnly == nil {
+				pwm.writeOnly = make(map[string]bool)
+			}
+			pwm.writeOnly[path] = true
+		}
+	}
+	return file, err
+}
+
+// readAttribute reads the integer value of an opened sysfs attribute file.
+func (pwm *PWM) readAttribute(file *os.File) (int64, error) {
+	if pwm.writeOnly[file.Name()] {
+		return 0, fmt.Errorf("PWM %s can't read %s, it was opened write-only for lack of permission", pwm.key, file.Name())
+	}
+	return embedded.ReadSysfsFileInt64(file)
+}
+
+// Healthy checks if the period read back from sysfs
+// matches the period set with SetPeriod.
+func (pwm *PWM) Healthy() error {
+	period, err := pwm.readAttribute(pwm.periodFile)
+	if err != nil {
+		return err
+	}
+	if time.Duration(period) != pwm.period {
+		return fmt.Errorf("PWM %s period is %s instead of %s", pwm.key, time.Duration(period), pwm.period)
+	}
+	return nil
+}
+
+// PeriodRange returns the minimum and maximum period in nanoseconds
+// that the PWM can produce.
+// Neither the pwm_test nor the pwmchip sysfs layout exposes
+// the limits of the hardware timer, so the range has to be
+// set from the datasheet with SetPeriodRange.
+// Without it ErrPeriodRangeUnknown is returned.
+func (pwm *PWM) PeriodRange() (min, max uint64, err error) {
+	if pwm.maxPeriod == 0 {
+		return 0, 0, ErrPeriodRangeUnknown
+	}
+	return pwm.minPeriod, pwm.maxPeriod, nil
+}
+
+// SetPeriodRange sets the valid period range in nanoseconds
+// that SetPeriod validates against.
+// A max of zero makes the range unknown again.
+func (pwm *PWM) SetPeriodRange(min, max uint64) error {
+	if max != 0 && min > max {
+		return fmt.Errorf("PWM %s minimum period %d greater than maximum %d", pwm.key, min, max)
+	}
+	pwm.minPeriod = min
+	pwm.maxPeriod = max
+	return nil
+}
+
 func (pwm *PWM) Period() time.Duration {
 	return pwm.period
 }
 
+// SetPeriod sets the PWM period with nanosecond resolution.
+// Periods longer than the 32 bit nanosecond range of about 4.29s
+// are passed through to the driver unchanged.
+// If a range was set with SetPeriodRange, periods outside of it
+// are rejected before writing to the driver.
 func (pwm *PWM) SetPeriod(period time.Duration) error {
-	_, err := fmt.Fprintf(pwm.periodFile, "%d", period)
+	if period < 0 {
+		return fmt.Errorf("PWM period %s must not be negative", period)
+	}
+	if pwm.maxPeriod != 0 && (uint64(period) < pwm.minPeriod || uint64(period) > pwm.maxPeriod) {
+		return fmt.Errorf("PWM %s period %s not in the range %s to %s", pwm.key, period, time.Duration(pwm.minPeriod), time.Duration(pwm.maxPeriod))
+	}
+	err := writeFileInt64(pwm.periodFile, int64(period))
 	if err != nil {
 		return err
 	}
@@ -138,7 +340,10 @@ func (pwm *PWM) Duty() time.Duration {
 }
 
 func (pwm *PWM) SetDuty(duty time.Duration) error {
-	_, err := fmt.Fprintf(pwm.dutyFile, "%d", duty)
+	if duty < 0 {
+		return fmt.Errorf("PWM duty %s must not be negative", duty)
+	}
+	err := writeFileInt64(pwm.dutyFile, int64(duty))
 	if err != nil {
 		return err
 	}
@@ -150,11 +355,62 @@ func (pwm *PWM) Polarity() Polarity {
 	return pwm.polarity
 }
 
+// SetPolarity sets the polarity of the PWM.
+// For the pwmchip layout POLARITY_LOW is written as "normal"
+// and POLARITY_HIGH as "inversed".
 func (pwm *PWM) SetPolarity(polarity Polarity) error {
-	_, err := fmt.Fprintf(pwm.polarityFile, "%d", polarity)
+	var err error
+	if pwm.chipPath != "" {
+		name := "normal"
+		if polarity != POLARITY_LOW {
+			name = "inversed"
+		}
+		err = embedded.WriteSysfsFileString(pwm.polarityFile, name)
+	} else {
+		err = writeFileInt64(pwm.polarityFile, int64(polarity))
+	}
 	if err != nil {
 		return err
 	}
 	pwm.polarity = polarity
 	return nil
 }
+
+// Enabled returns if the output was enabled with SetEnabled.
+// PWMs of the pwm_test layout are always enabled.
+func (pwm *PWM) Enabled() bool {
+	return pwm.enableFile == nil || pwm.enabled
+}
+
+// ReadEnabled reads the enable file of the pwmchip layout, so diagnostics
+// can detect when the hardware disabled the output, in contrast to
+// the cached state returned by Enabled.
+// PWMs of the pwm_test layout are always enabled.
+func (pwm *PWM) ReadEnabled() (bool, error) {
+	if pwm.enableFile == nil {
+		return true, nil
+	}
+	value, err := pwm.readAttribute(pwm.enableFile)
+	if err != nil {
+		return false, err
+	}
+	return value != 0, nil
+}
+
+// SetEnabled enables or disables the output.
+// Only PWMs of the pwmchip layout can be disabled.
+func (pwm *PWM) SetEnabled(enabled bool) error {
+	if pwm.enableFile == nil {
+		return fmt.Errorf("PWM %s can't be enabled or disabled", pwm.key)
+	}
+	value := 0
+	if enabled {
+		value = 1
+	}
+	err := writeFileInt64(pwm.enableFile, int64(value))
+	if err != nil {
+		return err
+	}
+	pwm.enabled = enabled
+	return nil
+}
@@ -0,0 +1,244 @@
+package pwm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/SpaceLeap/go-embedded"
+)
+
+// fakeChip creates the files of channel of PWM chip 0 in a temporary
+// class path, set with SetClassPath until the end of the test,
+// and returns the directory of the channel.
+func fakeChip(t *testing.T, channel int) string {
+	dir := t.TempDir()
+	saved := classPath
+	SetClassPath(dir)
+	t.Cleanup(func() { SetClassPath(saved) })
+
+	chipDir := filepath.Join(dir, "pwmchip0")
+	channelDir := filepath.Join(chipDir, "pwm"+strconv.Itoa(channel))
+	if err := os.MkdirAll(channelDir, 0770); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		filepath.Join(chipDir, "export"):        "",
+		filepath.Join(chipDir, "unexport"):      "",
+		filepath.Join(channelDir, "period"):     "0\n",
+		filepath.Join(channelDir, "duty_cycle"): "0\n",
+		filepath.Join(channelDir, "polarity"):   "normal\n",
+		filepath.Join(channelDir, "enable"):     "0\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return channelDir
+}
+
+func readFile(t *testing.T, name string) string {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func TestNewPWMChip(t *testing.T) {
+	channelDir := fakeChip(t, 1)
+
+	pwm, err := NewPWMChip(0, 1, time.Millisecond, 250*time.Microsecond, POLARITY_HIGH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"period":     "1000000",
+		"duty_cycle": "250000",
+		"polarity":   "inversed",
+		"enable":     "1",
+	}
+	for name, value := range expected {
+		if v := readFile(t, filepath.Join(channelDir, name)); v != value {
+			t.Errorf("%s is %q instead of %q", name, v, value)
+		}
+	}
+
+	if err = pwm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if v := readFile(t, filepath.Join(channelDir, "enable")); v != "0" {
+		t.Errorf("enable is %q after Close", v)
+	}
+	if v := readFile(t, filepath.Join(classPath, "pwmchip0", "unexport")); v != "1" {
+		t.Errorf("unexport is %q after Close", v)
+	}
+}
+
+func TestWriteOnlyAttributes(t *testing.T) {
+	channelDir := fakeChip(t, 1)
+	saved := openFile
+	t.Cleanup(func() { openFile = saved })
+	// the period and enable files can't be read like
+	// with a udev rule only granting write access
+	openFile = func(name string, flag int, perm os.FileMode) (*os.File, error) {
+		base := filepath.Base(name)
+		if flag&os.O_RDWR != 0 && (base == "period" || base == "enable") {
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EACCES}
+		}
+		return saved(name, flag, perm)
+	}
+
+	pwm, err := NewPWMChip(0, 1, time.Millisecond, 250*time.Microsecond, POLARITY_LOW)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pwm.Close()
+
+	if err = pwm.SetPeriod(2 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if v := readFile(t, filepath.Join(channelDir, "period")); v != "2000000" {
+		t.Errorf("period is %q instead of \"2000000\"", v)
+	}
+	if err = pwm.Healthy(); err == nil || !strings.Contains(err.Error(), "write-only") {
+		t.Errorf("Healthy returned %v instead of a write-only error", err)
+	}
+	if _, err = pwm.ReadEnabled(); err == nil || !strings.Contains(err.Error(), "write-only") {
+		t.Errorf("ReadEnabled returned %v instead of a write-only error", err)
+	}
+}
+
+func TestCloseJoinsErrors(t *testing.T) {
+	// a capemgr directory without slots file makes unloading fail
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "bone_capemgr.9"), 0770); err != nil {
+		t.Fatal(err)
+	}
+	saved := embedded.SysDevicesBase
+	embedded.SysDevicesBase = dir
+	defer func() { embedded.SysDevicesBase = saved }()
+	if err := embedded.Init("bone_capemgr"); err != nil {
+		t.Fatal(err)
+	}
+
+	var files [3]*os.File
+	for i := range files {
+		file, err := os.CreateTemp(dir, "attribute")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[i] = file
+	}
+	// closing the period file again fails
+	files[0].Close()
+	pwm := &PWM{key: "P9_14", periodFile: files[0], dutyFile: files[1], polarityFile: files[2]}
+
+	err := pwm.Close()
+	if !errors.Is(err, os.ErrClosed) {
+		t.Errorf("error %v does not contain the failed file close", err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error %v does not contain the failed overlay unload", err)
+	}
+}
+
+// fakePWMTest creates a capemgr with the overlay of the PWM key
+// loaded and the files of its pwm_test device in a temporary
+// sysfs tree, and returns the directory of the device.
+func fakePWMTest(t *testing.T, key string) string {
+	dir := t.TempDir()
+	pwmDir := filepath.Join(dir, "ocp.3", "pwm_test_"+key+".15")
+	for _, sub := range []string{filepath.Join(dir, "bone_capemgr.9"), pwmDir} {
+		if err := os.MkdirAll(sub, 0770); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slots := " 0: 54:PF--- \n 7: ff:P-O-L Override Board Name,00A0,Override Manuf,bone_pwm_" + key + "\n"
+	files := map[string]string{
+		filepath.Join(dir, "bone_capemgr.9", "slots"): slots,
+		filepath.Join(pwmDir, "period"):               "0\n",
+		filepath.Join(pwmDir, "duty"):                 "0\n",
+		filepath.Join(pwmDir, "polarity"):             "0\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	savedBase, savedPrefix := embedded.SysDevicesBase, devicePrefix
+	t.Cleanup(func() {
+		embedded.SysDevicesBase, devicePrefix = savedBase, savedPrefix
+		embedded.RefreshDeviceTreeCache()
+	})
+	embedded.SysDevicesBase = dir
+	devicePrefix = "bone_pwm_"
+	if err := embedded.Init("bone_capemgr"); err != nil {
+		t.Fatal(err)
+	}
+	embedded.RefreshDeviceTreeCache()
+	return pwmDir
+}
+
+func TestNewServoPeriod(t *testing.T) {
+	tests := []struct {
+		period   time.Duration
+		position float32
+		duty     string
+	}{
+		{ServoPeriod, 0.5, "1500000"},           // 50Hz
+		{ServoPeriod, 0, "700000"},              // 50Hz
+		{3 * time.Millisecond, 1, "2300000"},    // 333Hz
+		{3 * time.Millisecond, 0.25, "1100000"}, // 333Hz
+	}
+	for _, test := range tests {
+		pwmDir := fakePWMTest(t, "P9_14")
+		servo, err := NewServoPeriod("P9_14", test.period, test.position)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{
+			"period":   strconv.FormatInt(int64(test.period), 10),
+			"duty":     test.duty,
+			"polarity": "0",
+		}
+		for name, value := range expected {
+			if v := readFile(t, filepath.Join(pwmDir, name)); v != value {
+				t.Errorf("period %s, position %g: %s is %q instead of %q", test.period, test.position, name, v, value)
+			}
+		}
+		if servo.Position() != test.position {
+			t.Errorf("Position is %g instead of %g", servo.Position(), test.position)
+		}
+		if err = servo.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the longest pulse of 2.3ms must be shorter than the period
+	for _, period := range []time.Duration{2300 * time.Microsecond, 2 * time.Millisecond} {
+		pwmDir := fakePWMTest(t, "P9_14")
+		if _, err := NewServoPeriod("P9_14", period, 0.5); err == nil {
+			t.Errorf("NewServoPeriod accepted the period %s", period)
+		}
+		if v := readFile(t, filepath.Join(pwmDir, "period")); v != "0" {
+			t.Errorf("NewServoPeriod with the period %s wrote the period %q", period, v)
+		}
+	}
+	pwmDir := fakePWMTest(t, "P9_14")
+	servo, err := NewServoPeriod("P9_14", 2400*time.Microsecond, 1)
+	if err != nil {
+		t.Fatalf("NewServoPeriod rejected a period longer than the longest pulse: %v", err)
+	}
+	defer servo.Close()
+	if v := readFile(t, filepath.Join(pwmDir, "duty")); v != "2300000" {
+		t.Errorf("duty is %q instead of \"2300000\"", v)
+	}
+}
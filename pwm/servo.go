@@ -1,6 +1,9 @@
 package pwm
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 var (
 	ServoCenter time.Duration = 1500 * time.Microsecond
@@ -14,10 +17,26 @@ func servoPositionToDuty(position float32) time.Duration {
 type Servo struct {
 	pwm      *PWM
 	position float32
+	detached bool
 }
 
+// ServoPeriod is the standard servo frame period of 20ms (50Hz).
+const ServoPeriod = 20 * time.Millisecond
+
+// NewServo returns a Servo using the standard frame period ServoPeriod.
 func NewServo(key string, position float32) (*Servo, error) {
-	pwm, err := NewPWM(key, 2e7, servoPositionToDuty(position), POLARITY_LOW)
+	return NewServoPeriod(key, ServoPeriod, position)
+}
+
+// NewServoPeriod returns a Servo with a custom frame period,
+// for example 3ms (333Hz) for fast digital servos.
+// The pulse width is still calibrated by ServoCenter and ServoRange,
+// the longest pulse of ServoCenter+ServoRange/2 must be shorter than period.
+func NewServoPeriod(key string, period time.Duration, position float32) (*Servo, error) {
+	if maxPulse := ServoCenter + ServoRange/2; maxPulse >= period {
+		return nil, fmt.Errorf("Servo pulse width up to %s does not fit into period %s", maxPulse, period)
+	}
+	pwm, err := NewPWM(key, period, servoPositionToDuty(position), POLARITY_LOW)
 	if err != nil {
 		return nil, err
 	}
@@ -35,12 +54,18 @@ func (servo *Servo) Position() float32 {
 
 // SetPosition sets the servo position in the range from 0.0 to 1.0.
 // position will be clamped if outside 0.0 to 1.0
+// If the servo is detached, the position is only stored
+// and will be used by Attach.
 func (servo *Servo) SetPosition(position float32) error {
 	if position < 0 {
 		position = 0
 	} else if position > 1 {
 		position = 1
 	}
+	if servo.detached {
+		servo.position = position
+		return nil
+	}
 	err := servo.pwm.SetDuty(servoPositionToDuty(position))
 	if err != nil {
 		return err
@@ -49,6 +74,33 @@ func (servo *Servo) SetPosition(position float32) error {
 	return nil
 }
 
+// Attached returns if the servo receives pulses.
+func (servo *Servo) Attached() bool {
+	return !servo.detached
+}
+
+// Detach stops the pulses by setting the duty to zero without closing
+// the PWM, so that servos that hold their position passively
+// don't draw current or hum.
+func (servo *Servo) Detach() error {
+	err := servo.pwm.SetDuty(0)
+	if err != nil {
+		return err
+	}
+	servo.detached = true
+	return nil
+}
+
+// Attach resumes the pulses for the stored position after Detach.
+func (servo *Servo) Attach() error {
+	err := servo.pwm.SetDuty(servoPositionToDuty(servo.position))
+	if err != nil {
+		return err
+	}
+	servo.detached = false
+	return nil
+}
+
 // Closes the current servo instance
 func (servo *Servo) Close() error {
 	return servo.pwm.Close()
@@ -0,0 +1,66 @@
+package embedded
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Report returns a description of the environment detected by the library
+// for bug reports: the platform, the capemgr directory, the loaded
+// device tree overlays, the I2C buses, the SPI devices and the exported GPIOs.
+// It only reads and reports missing subsystems as "none".
+func Report() (string, error) {
+	var buf bytes.Buffer
+
+	model, err := ReadSysfsString("/proc/device-tree/model")
+	if err != nil {
+		model = "unknown"
+	}
+	// the device tree strings are null terminated
+	fmt.Fprintf(&buf, "Platform: %s\n", strings.TrimRight(model, "\x00"))
+
+	dir := ctrlDir
+	if dir == "" {
+		dir = "none, Init not called"
+	}
+	fmt.Fprintf(&buf, "Device tree control dir: %s\n", dir)
+
+	var overlays []string
+	if ctrlDir != "" {
+		RefreshDeviceTreeCache()
+		slots, err := readSlots()
+		if err == nil {
+			for _, slot := range slots {
+				if slot.Name != "" {
+					overlays = append(overlays, fmt.Sprintf("%d: %s %s", slot.Index, slot.Flags, slot.Name))
+				}
+			}
+		}
+	}
+	reportList(&buf, "Loaded overlays", overlays)
+
+	i2cBuses, _ := filepath.Glob("/dev/i2c-*")
+	reportList(&buf, "I2C buses", i2cBuses)
+	spiDevices, _ := filepath.Glob("/dev/spidev*")
+	reportList(&buf, "SPI devices", spiDevices)
+	gpios, _ := filepath.Glob("/sys/class/gpio/gpio[0-9]*")
+	for i := range gpios {
+		gpios[i] = filepath.Base(gpios[i])
+	}
+	reportList(&buf, "Exported GPIOs", gpios)
+
+	return buf.String(), nil
+}
+
+func reportList(buf *bytes.Buffer, title string, items []string) {
+	if len(items) == 0 {
+		fmt.Fprintf(buf, "%s: none\n", title)
+		return
+	}
+	fmt.Fprintf(buf, "%s:\n", title)
+	for _, item := range items {
+		fmt.Fprintf(buf, "  %s\n", item)
+	}
+}
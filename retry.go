@@ -0,0 +1,62 @@
+package embedded
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// RetryableErrors are the errors that Retry and RetryContext retry on.
+// They are matched with errors.Is, so wrapped errnos are found too.
+// The defaults are the transient errnos of flaky buses and busy devices:
+// EAGAIN, EINTR, EIO, EBUSY and ETIMEDOUT.
+var RetryableErrors = []error{
+	syscall.EAGAIN,
+	syscall.EINTR,
+	syscall.EIO,
+	syscall.EBUSY,
+	syscall.ETIMEDOUT,
+}
+
+// IsRetryable returns if err matches one of RetryableErrors.
+func IsRetryable(err error) bool {
+	for _, retryable := range RetryableErrors {
+		if errors.Is(err, retryable) {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry calls fn up to attempts times as long as it returns
+// a retryable error, see RetryContext.
+func Retry(attempts int, backoff time.Duration, fn func() error) error {
+	return RetryContext(context.Background(), attempts, backoff, fn)
+}
+
+// RetryContext calls fn up to attempts times as long as it returns
+// an error matching RetryableErrors.
+// The wait before the next attempt starts with backoff
+// and doubles after every attempt.
+// Other errors are returned immediately.
+// If all attempts fail, the returned error wraps the last failure.
+// If ctx is done while waiting, the returned error wraps
+// the context error and the last failure.
+func RetryContext(ctx context.Context, attempts int, backoff time.Duration, fn func() error) error {
+	err := fn()
+	for i := 1; i < attempts && IsRetryable(err); i++ {
+		select {
+		case <-ctx.Done():
+			return errors.Join(ctx.Err(), err)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		err = fn()
+	}
+	if err != nil && IsRetryable(err) {
+		return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+	}
+	return err
+}
@@ -5,8 +5,10 @@ package spi
 import "C"
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync"
 	"syscall"
 	"unsafe"
 
@@ -50,19 +52,37 @@ func Init(deviceTree string) {
 	deviceTreePrefix = deviceTree
 }
 
+// Transport is the interface of the SPI transfers implemented by SPI.
+// Code depending on Transport instead of *SPI can be tested
+// with spitest.Fake.
+type Transport interface {
+	Xfer2(txBuf []byte, delay_usecs uint16) (rxBuf []byte, err error)
+	Read(data []byte) (n int, err error)
+	Write(data []byte) (n int, err error)
+	Close() error
+}
+
+var _ Transport = &SPI{}
+
 type SPI struct {
 	bus         int
 	device      int
+	path        string   /* opened device path */
 	file        *os.File /* open file descriptor: /dev/spi-X.Y */
 	mode        uint8    /* current SPI mode */
 	bitsPerWord uint8    /* current SPI bits per word setting */
 	maxSpeedHz  uint32   /* current SPI max speed setting in Hz */
+	zeros       []byte   /* zeroed tx buffer for ReadOnly */
+	wordDelay   uint8    /* delay between words within a transfer in usec */
+	skipVerify  bool     /* don't read back settings after writing them */
+
+	zerosMutex sync.Mutex /* guards zeros */
 }
 
 // NewSPI returns a new SPI object that is connected to the
 // specified SPI device interface.
 //
-// NewSPI(X,Y) will open /dev/spidev-X.Y
+// NewSPI(X,Y) will open /dev/spidev(X+1).Y
 //
 // SPI is an object type that allows SPI transactions
 // on hosts running the Linux kernel. The host kernel must have SPI
@@ -77,32 +97,53 @@ func NewSPI(bus, device int) (spi *SPI, err error) {
 		return nil, err
 	}
 
-	spi = &SPI{bus: bus, device: device}
-
 	path := fmt.Sprintf("/dev/spidev%d.%d", bus+1, device)
+	spi = &SPI{bus: bus, device: device, path: path}
+
+	ctx, cancel := context.WithTimeout(context.Background(), embedded.DeviceTreeTimeout)
+	defer cancel()
+	err = embedded.WaitForPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
 	spi.file, err = os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
 		return nil, err
 	}
 
-	r, _, err := syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), C.SPI_IOC_RD_MODE, uintptr(unsafe.Pointer(&spi.mode)))
-	if r != 0 {
+	err = ioctl(spi.file.Fd(), C.SPI_IOC_RD_MODE, unsafe.Pointer(&spi.mode))
+	if err != nil {
 		return nil, err
 	}
 
-	r, _, err = syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), C.SPI_IOC_RD_BITS_PER_WORD, uintptr(unsafe.Pointer(&spi.bitsPerWord)))
-	if r != 0 {
+	err = ioctl(spi.file.Fd(), C.SPI_IOC_RD_BITS_PER_WORD, unsafe.Pointer(&spi.bitsPerWord))
+	if err != nil {
 		return nil, err
 	}
 
-	r, _, err = syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), C.SPI_IOC_RD_MAX_SPEED_HZ, uintptr(unsafe.Pointer(&spi.maxSpeedHz)))
-	if r != 0 {
+	err = ioctl(spi.file.Fd(), C.SPI_IOC_RD_MAX_SPEED_HZ, unsafe.Pointer(&spi.maxSpeedHz))
+	if err != nil {
 		return nil, err
 	}
 
 	return spi, nil
 }
 
+// Path returns the opened device path.
+// Note that the bus number of the path is bus+1.
+func (spi *SPI) Path() string {
+	return spi.path
+}
+
+func (spi *SPI) Bus() int {
+	return spi.bus
+}
+
+func (spi *SPI) Device() int {
+	return spi.device
+}
+
 // Disconnects the object from the interface.
 func (spi *SPI) Close() error {
 	return spi.file.Close()
@@ -113,20 +154,76 @@ func (spi *SPI) Read(data []byte) (n int, err error) {
 	return spi.file.Read(data)
 }
 
+// writeFile writes data to the device file.
+// It is a variable so that tests can replace the kernel with a fake device.
+var writeFile = func(file *os.File, data []byte) (int, error) {
+	return file.Write(data)
+}
+
 // Write data to SPI device.
 func (spi *SPI) Write(data []byte) (n int, err error) {
-	return spi.file.Write(data)
+	return writeFile(spi.file, data)
+}
+
+var bufsizPath = "/sys/module/spidev/parameters/bufsiz"
+
+// MaxTransferSize returns the maximum size of a single transfer
+// of the spidev driver, as configured by its bufsiz module parameter.
+// If the parameter can't be read, the kernel default of 4096 is returned.
+func MaxTransferSize() int {
+	size, err := embedded.ReadSysfsInt(bufsizPath)
+	if err != nil || size <= 0 {
+		return 4096
+	}
+	return size
 }
 
+// WriteLarge writes data in transfers of chunk bytes,
+// limited to MaxTransferSize, and calls progress if not nil
+// with the number of bytes written so far after every transfer.
+// If chunk is not positive, MaxTransferSize is used.
+func (spi *SPI) WriteLarge(data []byte, chunk int, progress func(done int)) error {
+	max := MaxTransferSize()
+	if chunk <= 0 || chunk > max {
+		chunk = max
+	}
+	for done := 0; done < len(data); {
+		end := done + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		n, err := spi.Write(data[done:end])
+		if err != nil {
+			return err
+		}
+		done += n
+		if progress != nil {
+			progress(done)
+		}
+	}
+	return nil
+}
+
+// spi_ioc_transfer mirrors struct spi_ioc_transfer of linux/spi/spidev.h.
+//
+// cs_change has an inverted meaning for the last transfer of a message:
+// between transfers a non-zero value deasserts CS before the next one,
+// after the last transfer it keeps CS asserted after the message.
+// The transfer methods of SPI leave it zero and deassert CS
+// after every message, see DeassertCS, so CS can't be held
+// across multiple transfer calls.
 type spi_ioc_transfer struct {
-	tx_buf        uintptr
-	rx_buf        uintptr
-	len           uint32
-	speed_hz      uint32
-	delay_usecs   uint16
-	bits_per_word uint8
-	cs_change     uint8
-	pad           uint32
+	tx_buf           uint64 // __u64 in the kernel, also on 32 bit platforms
+	rx_buf           uint64
+	len              uint32
+	speed_hz         uint32
+	delay_usecs      uint16
+	bits_per_word    uint8
+	cs_change        uint8
+	tx_nbits         uint8
+	rx_nbits         uint8
+	word_delay_usecs uint8
+	pad              uint8
 }
 
 // Xfer performs a SPI transaction.
@@ -138,24 +235,16 @@ func (spi *SPI) Xfer(txBuf []byte, delay_usecs uint16) (rxBuf []byte, err error)
 
 	xfer := make([]spi_ioc_transfer, length)
 	for i := range xfer {
-		xfer[i].tx_buf = uintptr(unsafe.Pointer(&txBuf[i]))
-		xfer[i].rx_buf = uintptr(unsafe.Pointer(&rxBuf[i]))
+		xfer[i].tx_buf = uint64(uintptr(unsafe.Pointer(&txBuf[i])))
+		xfer[i].rx_buf = uint64(uintptr(unsafe.Pointer(&rxBuf[i])))
 		xfer[i].len = 1
 		xfer[i].delay_usecs = delay_usecs
 	}
 
-	SPI_IOC_MESSAGE := C._IOC_WRITE<<C._IOC_DIRSHIFT | C.SPI_IOC_MAGIC<<C._IOC_TYPESHIFT | length<<C._IOC_SIZESHIFT
-
-	r, _, err := syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), uintptr(SPI_IOC_MESSAGE), uintptr(unsafe.Pointer(&xfer[0])))
-	if r != 0 {
+	err = spi.message(xfer)
+	if err != nil {
 		return nil, err
 	}
-
-	// WA:
-	// in CS_HIGH mode CS isn't pulled to low after transfer, but after read
-	// reading 0 bytes doesnt matter but brings cs down
-	syscall.Syscall(syscall.SYS_READ, spi.file.Fd(), uintptr(unsafe.Pointer(&rxBuf[0])), 0)
-
 	return rxBuf, nil
 }
 
@@ -163,27 +252,314 @@ func (spi *SPI) Xfer(txBuf []byte, delay_usecs uint16) (rxBuf []byte, err error)
 // CS will be held active between blocks.
 func (spi *SPI) Xfer2(txBuf []byte, delay_usecs uint16) (rxBuf []byte, err error) {
 	length := len(txBuf)
+	if err = spi.checkWordAlignment(length); err != nil {
+		return nil, err
+	}
 	rxBuf = make([]byte, length)
 
 	xfer := spi_ioc_transfer{
-		tx_buf: uintptr(unsafe.Pointer(&txBuf[0])),
-		rx_buf: uintptr(unsafe.Pointer(&rxBuf[0])),
-		len:    uint32(length),
+		tx_buf:      uint64(uintptr(unsafe.Pointer(&txBuf[0]))),
+		rx_buf:      uint64(uintptr(unsafe.Pointer(&rxBuf[0]))),
+		len:         uint32(length),
+		delay_usecs: delay_usecs,
 	}
 
-	SPI_IOC_MESSAGE := C._IOC_WRITE<<C._IOC_DIRSHIFT | C.SPI_IOC_MAGIC<<C._IOC_TYPESHIFT | 1<<C._IOC_SIZESHIFT
+	err = spi.message([]spi_ioc_transfer{xfer})
+	if err != nil {
+		return nil, err
+	}
+	return rxBuf, nil
+}
 
-	r, _, err := syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), uintptr(SPI_IOC_MESSAGE), uintptr(unsafe.Pointer(&xfer)))
-	if r != 0 {
+// XferInPlace performs a SPI transaction like Xfer2, but receives
+// into buf, overwriting the sent bytes, to save the allocation
+// of a receive buffer when polling in a loop.
+// spidev copies the sent bytes into its own buffer before the transfer
+// and the received ones back afterwards, so sharing the buffer is safe.
+func (spi *SPI) XferInPlace(buf []byte, delay_usecs uint16) error {
+	length := len(buf)
+	if err := spi.checkWordAlignment(length); err != nil {
+		return err
+	}
+	xfer := spi_ioc_transfer{
+		tx_buf:      uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		rx_buf:      uint64(uintptr(unsafe.Pointer(&buf[0]))),
+		len:         uint32(length),
+		delay_usecs: delay_usecs,
+	}
+	return spi.message([]spi_ioc_transfer{xfer})
+}
+
+// XferTrailingDelay performs a SPI transaction like Xfer2 with CS held
+// active, but distinguishes the delay between the words from the delay
+// after the last word before CS is released.
+// The trailing delay is a final zero-length transfer with delay_usecs set.
+// txBuf must not be empty.
+// If interByteUsecs is not zero, every word is sent as a separate transfer,
+// a word is one byte for up to 8 bits per word, 2 bytes for up to 16
+// and 4 bytes above:
+//
+//	CS   --\___________________________________________________/--
+//	DATA     [word 0] interByte [word 1] interByte [word 2] trailing
+//
+// If interByteUsecs is zero, the words are sent as one block
+// followed by the trailing delay.
+func (spi *SPI) XferTrailingDelay(txBuf []byte, interByteUsecs, trailingUsecs uint16) (rxBuf []byte, err error) {
+	length := len(txBuf)
+	if err = spi.checkWordAlignment(length); err != nil {
+		return nil, err
+	}
+	rxBuf = make([]byte, length)
+
+	var xfer []spi_ioc_transfer
+	if interByteUsecs == 0 {
+		xfer = append(xfer, spi_ioc_transfer{
+			tx_buf: uint64(uintptr(unsafe.Pointer(&txBuf[0]))),
+			rx_buf: uint64(uintptr(unsafe.Pointer(&rxBuf[0]))),
+			len:    uint32(length),
+		})
+	} else {
+		xfer = spi.wordTransfers(txBuf, rxBuf, interByteUsecs)
+		xfer[len(xfer)-1].delay_usecs = 0
+	}
+	// zero length transfer that only delays with CS active
+	xfer = append(xfer, spi_ioc_transfer{delay_usecs: trailingUsecs})
+
+	err = spi.message(xfer)
+	if err != nil {
+		return nil, err
+	}
+	return rxBuf, nil
+}
+
+// ThreeWireXfer performs a half-duplex transaction in three-wire mode
+// with CS held active: it first writes txBuf and then reads readLen bytes
+// over the shared SI/SO line.
+// An error is returned if three-wire mode is not set, see SetThreeWire.
+func (spi *SPI) ThreeWireXfer(txBuf []byte, readLen int) (rxBuf []byte, err error) {
+	if !spi.ThreeWire() {
+		return nil, fmt.Errorf("SPI three-wire mode is not set")
+	}
+	if readLen < 0 || len(txBuf) == 0 && readLen == 0 {
+		return nil, fmt.Errorf("SPI three-wire transfer needs bytes to write or read")
+	}
+	if len(txBuf) > 0 {
+		if err = spi.checkWordAlignment(len(txBuf)); err != nil {
+			return nil, err
+		}
+	}
+	if readLen > 0 {
+		if err = spi.checkWordAlignment(readLen); err != nil {
+			return nil, err
+		}
+	}
+	rxBuf = make([]byte, readLen)
+
+	// the kernel rejects transfers in both directions in three-wire mode
+	var xfer []spi_ioc_transfer
+	if len(txBuf) > 0 {
+		xfer = append(xfer, spi_ioc_transfer{
+			tx_buf: uint64(uintptr(unsafe.Pointer(&txBuf[0]))),
+			len:    uint32(len(txBuf)),
+		})
+	}
+	if readLen > 0 {
+		xfer = append(xfer, spi_ioc_transfer{
+			rx_buf: uint64(uintptr(unsafe.Pointer(&rxBuf[0]))),
+			len:    uint32(readLen),
+		})
+	}
+
+	err = spi.message(xfer)
+	if err != nil {
 		return nil, err
 	}
+	return rxBuf, nil
+}
+
+// XferPadded performs a full-duplex SPI transaction of totalLen bytes
+// with CS held active, sending txBuf followed by zeros.
+// It returns all totalLen received bytes, so the caller can slice out
+// the response window of protocols with a short command and a long response.
+func (spi *SPI) XferPadded(txBuf []byte, totalLen int) (rxBuf []byte, err error) {
+	if totalLen < 1 || totalLen < len(txBuf) {
+		return nil, fmt.Errorf("SPI total length %d must be at least 1 and not less than the %d bytes to send", totalLen, len(txBuf))
+	}
+	padded := make([]byte, totalLen)
+	copy(padded, txBuf)
+	return spi.Xfer2(padded, 0)
+}
+
+// XferWithCSSetup performs a SPI transaction like Xfer2,
+// but holds CS active for csSetupUsecs before the first clock edge
+// for devices that need a setup time after CS becomes active.
+// If interByteUsecs is not zero, every word is sent as a separate transfer
+// followed by interByteUsecs, see XferTrailingDelay for the word size.
+// txBuf must not be empty.
+func (spi *SPI) XferWithCSSetup(txBuf []byte, csSetupUsecs, interByteUsecs uint16) (rxBuf []byte, err error) {
+	length := len(txBuf)
+	if err = spi.checkWordAlignment(length); err != nil {
+		return nil, err
+	}
+	rxBuf = make([]byte, length)
+
+	// zero length transfer that only delays with CS active
+	xfer := []spi_ioc_transfer{{delay_usecs: csSetupUsecs}}
+	if interByteUsecs == 0 {
+		xfer = append(xfer, spi_ioc_transfer{
+			tx_buf: uint64(uintptr(unsafe.Pointer(&txBuf[0]))),
+			rx_buf: uint64(uintptr(unsafe.Pointer(&rxBuf[0]))),
+			len:    uint32(length),
+		})
+	} else {
+		xfer = append(xfer, spi.wordTransfers(txBuf, rxBuf, interByteUsecs)...)
+	}
+
+	err = spi.message(xfer)
+	if err != nil {
+		return nil, err
+	}
+	return rxBuf, nil
+}
+
+// spiIocMessage returns the request number of the SPI_IOC_MESSAGE(n) ioctl
+// for n transfers like the kernel macro, or 0 if the size of n transfers
+// doesn't fit into the size field of the request number.
+func spiIocMessage(n int) uintptr {
+	size := uintptr(n) * unsafe.Sizeof(spi_ioc_transfer{})
+	if n < 0 || size >= 1<<C._IOC_SIZEBITS {
+		return 0
+	}
+	return C._IOC_WRITE<<C._IOC_DIRSHIFT | C.SPI_IOC_MAGIC<<C._IOC_TYPESHIFT | size<<C._IOC_SIZESHIFT
+}
+
+// ioctl performs the ioctl syscall on the device file fd.
+// It is a variable so that tests can replace the kernel with a fake device.
+var ioctl = func(fd, request uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// message performs xfer as one SPI message with the SPI_IOC_MESSAGE ioctl.
+func (spi *SPI) message(xfer []spi_ioc_transfer) error {
+	SPI_IOC_MESSAGE := spiIocMessage(len(xfer))
+	if SPI_IOC_MESSAGE == 0 {
+		return fmt.Errorf("SPI message of %d transfers is too large", len(xfer))
+	}
+
+	for i := range xfer {
+		xfer[i].word_delay_usecs = spi.wordDelay
+	}
+
+	err := ioctl(spi.file.Fd(), SPI_IOC_MESSAGE, unsafe.Pointer(&xfer[0]))
+	if err != nil {
+		return err
+	}
 
 	// WA:
 	// in CS_HIGH mode CS isn't pulled to low after transfer, but after read
-	// reading 0 bytes doesnt matter but brings cs down
-	syscall.Syscall(syscall.SYS_READ, spi.file.Fd(), uintptr(unsafe.Pointer(&rxBuf[0])), 0)
+	spi.DeassertCS()
 
-	return rxBuf, nil
+	return nil
+}
+
+// DeassertCS performs a read of 0 bytes which deasserts CS.
+// In CS_HIGH mode CS isn't pulled to low after a transfer,
+// but after a read. The transfer methods call it automatically
+// after every transaction, it can be used to deassert CS explicitly,
+// for example after a sequence of Write calls.
+func (spi *SPI) DeassertCS() error {
+	return readZero(spi.file.Fd())
+}
+
+// readZero performs a read of 0 bytes from fd.
+// It is a variable so that tests can replace the kernel with a fake device.
+var readZero = func(fd uintptr) error {
+	var dummy byte
+	r, _, err := syscall.Syscall(syscall.SYS_READ, fd, uintptr(unsafe.Pointer(&dummy)), 0)
+	if r != 0 {
+		return err
+	}
+	return nil
+}
+
+// ReadOnly performs a SPI transaction like Xfer2 that sends n zero bytes
+// and returns the n received bytes.
+// It is meant for devices that clock out data while CS is active
+// and don't care about the data sent to them.
+// The zero bytes are sent from a cached buffer that is only grown,
+// so repeated reads don't allocate a throwaway send buffer.
+// The cache is guarded by a mutex, so ReadOnly can be called concurrently.
+func (spi *SPI) ReadOnly(n int, delay_usecs uint16) ([]byte, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("SPI read length %d must be at least 1", n)
+	}
+	spi.zerosMutex.Lock()
+	if len(spi.zeros) < n {
+		spi.zeros = make([]byte, n)
+	}
+	zeros := spi.zeros[:n]
+	spi.zerosMutex.Unlock()
+	return spi.Xfer2(zeros, delay_usecs)
+}
+
+// ReadRegister reads n bytes from a register of devices that expect
+// a register address byte followed by the data in one transaction.
+// readBit is ORed into the address to select a read,
+// commonly 0x80 for devices using the MSB as read/write bit.
+// Devices that also use a bit for multi-byte auto-increment
+// expect it in readBit too, for example 0xC0.
+func (spi *SPI) ReadRegister(reg uint8, readBit uint8, n int) ([]byte, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("SPI register read length %d must be at least 1", n)
+	}
+	txBuf := make([]byte, 1+n)
+	txBuf[0] = reg | readBit
+	rxBuf, err := spi.Xfer2(txBuf, 0)
+	if err != nil {
+		return nil, err
+	}
+	return rxBuf[1:], nil
+}
+
+// WriteRegister writes data to a register of devices that expect
+// a register address byte followed by the data in one transaction.
+// reg is sent unchanged, devices that select a write with a set bit
+// instead of a cleared read bit need that bit ORed into reg.
+func (spi *SPI) WriteRegister(reg uint8, data []byte) error {
+	_, err := spi.Xfer2(append([]byte{reg}, data...), 0)
+	return err
+}
+
+func (spi *SPI) WordDelay() uint8 {
+	return spi.wordDelay
+}
+
+// SetWordDelay sets the delay in usec between the words within
+// a transfer of all following transactions.
+// In contrast, the delay_usecs argument of the transfer methods
+// is applied after a block, before the next block or the CS change.
+// Kernels older than the word_delay_usecs field of spi_ioc_transfer
+// and controllers without word delay support silently ignore it.
+func (spi *SPI) SetWordDelay(usecs uint8) {
+	spi.wordDelay = usecs
+}
+
+// Healthy checks if the SPI device interface still reports
+// the configured mode.
+func (spi *SPI) Healthy() error {
+	var mode uint8
+	err := ioctl(spi.file.Fd(), C.SPI_IOC_RD_MODE, unsafe.Pointer(&mode))
+	if err != nil {
+		return err
+	}
+	if mode != spi.mode {
+		return fmt.Errorf("SPI %s mode is %X instead of %X", spi.path, mode, spi.mode)
+	}
+	return nil
 }
 
 func (spi *SPI) Mode() Mode {
@@ -209,6 +585,41 @@ func (spi *SPI) SetCSHigh(csHigh bool) error {
 	return spi.setModeFlag(csHigh, CS_HIGH)
 }
 
+// CSActiveHigh returns if CS is active high, same as CSHigh.
+func (spi *SPI) CSActiveHigh() bool {
+	return spi.CSHigh()
+}
+
+// SetCSActiveHigh sets if CS is active high by setting the CS_HIGH mode bit.
+// With false, CS idles high and is pulled low during transfers,
+// which is the default.
+// The polarity has no effect for devices without CS (NO_CS),
+// and loopback mode (LOOP) doesn't change the CS behavior.
+// Unlike SetCSHigh, it changes the bit with the 32 bit mode ioctls,
+// so the mode bits above the first 8, like TX_DUAL or RX_QUAD
+// configured by the device tree, are kept. The 8 bit mode ioctl
+// clears them. Kernels without the 32 bit mode ioctls fall back to SetCSHigh.
+func (spi *SPI) SetCSActiveHigh(activeHigh bool) error {
+	var mode uint32
+	err := ioctl(spi.file.Fd(), C.SPI_IOC_RD_MODE32, unsafe.Pointer(&mode))
+	if err == syscall.ENOTTY {
+		return spi.SetCSHigh(activeHigh)
+	}
+	if err != nil {
+		return err
+	}
+	if activeHigh {
+		mode |= uint32(CS_HIGH)
+	} else {
+		mode &^= uint32(CS_HIGH)
+	}
+	err = spi.setModeInt32(mode)
+	if err == nil {
+		spi.mode = uint8(mode)
+	}
+	return err
+}
+
 func (spi *SPI) LSBFirst() bool {
 	return spi.mode&LSB_FIRST != 0
 }
@@ -244,14 +655,18 @@ func (spi *SPI) SetBitsPerWord(bits uint8) error {
 		return fmt.Errorf("SPI bits per word %d outside of valid range 8 to 16", bits)
 	}
 
-	r, _, err := syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), C.SPI_IOC_WR_BITS_PER_WORD, uintptr(unsafe.Pointer(&bits)))
-	if r != 0 {
+	err := ioctl(spi.file.Fd(), C.SPI_IOC_WR_BITS_PER_WORD, unsafe.Pointer(&bits))
+	if err != nil {
 		return err
 	}
+	if spi.skipVerify {
+		spi.bitsPerWord = bits
+		return nil
+	}
 
 	var test uint8
-	r, _, err = syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), C.SPI_IOC_RD_BITS_PER_WORD, uintptr(unsafe.Pointer(&test)))
-	if r != 0 {
+	err = ioctl(spi.file.Fd(), C.SPI_IOC_RD_BITS_PER_WORD, unsafe.Pointer(&test))
+	if err != nil {
 		return err
 	}
 
@@ -263,19 +678,81 @@ func (spi *SPI) SetBitsPerWord(bits uint8) error {
 	}
 }
 
+// checkWordAlignment returns an error if length is zero or not a multiple
+// of the bytes per word for the current bits per word setting.
+// Like the kernel it treats a setting of 0 as 8 bits per word
+// and stores words of 9 to 16 bits in 2 bytes and larger ones in 4 bytes.
+func (spi *SPI) checkWordAlignment(length int) error {
+	if length < 1 {
+		return fmt.Errorf("SPI transfer length %d must be at least 1", length)
+	}
+	bytesPerWord := spi.bytesPerWord()
+	if length%bytesPerWord != 0 {
+		return fmt.Errorf("SPI transfer length %d is not a multiple of %d bytes per word for %d bits per word", length, bytesPerWord, spi.bitsPerWord)
+	}
+	return nil
+}
+
+// bytesPerWord returns the size of a word in the transfer buffers,
+// one byte for up to 8 bits per word, 2 bytes for up to 16 and 4 above.
+func (spi *SPI) bytesPerWord() int {
+	switch {
+	case spi.bitsPerWord <= 8:
+		return 1
+	case spi.bitsPerWord <= 16:
+		return 2
+	}
+	return 4
+}
+
+// wordTransfers returns one transfer per word of txBuf and rxBuf,
+// each followed by delayUsecs.
+func (spi *SPI) wordTransfers(txBuf, rxBuf []byte, delayUsecs uint16) []spi_ioc_transfer {
+	n := spi.bytesPerWord()
+	xfer := make([]spi_ioc_transfer, 0, len(txBuf)/n)
+	for i := 0; i < len(txBuf); i += n {
+		xfer = append(xfer, spi_ioc_transfer{
+			tx_buf:      uint64(uintptr(unsafe.Pointer(&txBuf[i]))),
+			rx_buf:      uint64(uintptr(unsafe.Pointer(&rxBuf[i]))),
+			len:         uint32(n),
+			delay_usecs: delayUsecs,
+		})
+	}
+	return xfer
+}
+
+// VerifyWrites returns if settings are read back after writing them.
+func (spi *SPI) VerifyWrites() bool {
+	return !spi.skipVerify
+}
+
+// SetVerifyWrites sets if SetMode, SetBitsPerWord, SetMaxSpeedHz
+// and the mode flag setters read back a setting after writing it,
+// to return an error if the driver did not accept the value.
+// The default is true. Disabling the verification halves the number
+// of ioctl syscalls for environments where the values are known to work,
+// like switching speeds per device on a shared bus.
+func (spi *SPI) SetVerifyWrites(verify bool) {
+	spi.skipVerify = !verify
+}
+
 func (spi *SPI) MaxSpeedHz() uint32 {
 	return spi.maxSpeedHz
 }
 
 func (spi *SPI) SetMaxSpeedHz(maxSpeedHz uint32) error {
-	r, _, err := syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), C.SPI_IOC_WR_MAX_SPEED_HZ, uintptr(unsafe.Pointer(&maxSpeedHz)))
-	if r != 0 {
+	err := ioctl(spi.file.Fd(), C.SPI_IOC_WR_MAX_SPEED_HZ, unsafe.Pointer(&maxSpeedHz))
+	if err != nil {
 		return err
 	}
+	if spi.skipVerify {
+		spi.maxSpeedHz = maxSpeedHz
+		return nil
+	}
 
 	var test uint32
-	r, _, err = syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), C.SPI_IOC_RD_MAX_SPEED_HZ, uintptr(unsafe.Pointer(&test)))
-	if r != 0 {
+	err = ioctl(spi.file.Fd(), C.SPI_IOC_RD_MAX_SPEED_HZ, unsafe.Pointer(&test))
+	if err != nil {
 		return err
 	}
 
@@ -301,15 +778,40 @@ func (spi *SPI) setModeFlag(flag bool, mask uint8) error {
 	return err
 }
 
+func (spi *SPI) setModeInt32(mode uint32) error {
+	err := ioctl(spi.file.Fd(), C.SPI_IOC_WR_MODE32, unsafe.Pointer(&mode))
+	if err != nil {
+		return err
+	}
+	if spi.skipVerify {
+		return nil
+	}
+
+	var test uint32
+	err = ioctl(spi.file.Fd(), C.SPI_IOC_RD_MODE32, unsafe.Pointer(&test))
+	if err != nil {
+		return err
+	}
+
+	if test == mode {
+		return nil
+	} else {
+		return fmt.Errorf("Could not set SPI mode %X", mode)
+	}
+}
+
 func (spi *SPI) setModeInt(mode uint8) error {
-	r, _, err := syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), C.SPI_IOC_WR_MODE, uintptr(unsafe.Pointer(&mode)))
-	if r != 0 {
+	err := ioctl(spi.file.Fd(), C.SPI_IOC_WR_MODE, unsafe.Pointer(&mode))
+	if err != nil {
 		return err
 	}
+	if spi.skipVerify {
+		return nil
+	}
 
 	var test uint8
-	r, _, err = syscall.Syscall(syscall.SYS_IOCTL, spi.file.Fd(), C.SPI_IOC_RD_MODE, uintptr(unsafe.Pointer(&test)))
-	if r != 0 {
+	err = ioctl(spi.file.Fd(), C.SPI_IOC_RD_MODE, unsafe.Pointer(&test))
+	if err != nil {
 		return err
 	}
 
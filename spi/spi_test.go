@@ -0,0 +1,684 @@
+package spi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// fakeIoctl replaces the ioctl syscall with f until the end of the test.
+func fakeIoctl(tb testing.TB, f func(request uintptr, arg unsafe.Pointer) error) {
+	saved := ioctl
+	ioctl = func(fd, request uintptr, arg unsafe.Pointer) error {
+		return f(request, arg)
+	}
+	tb.Cleanup(func() { ioctl = saved })
+}
+
+// transfers returns the transfers of a SPI_IOC_MESSAGE request.
+func transfers(request uintptr, arg unsafe.Pointer) []spi_ioc_transfer {
+	size := request >> 16 & (1<<14 - 1)
+	return unsafe.Slice((*spi_ioc_transfer)(arg), size/unsafe.Sizeof(spi_ioc_transfer{}))
+}
+
+// bufBytes returns the bytes of a tx_buf or rx_buf field of a transfer.
+// The pointer is read from the start of the field, which holds it
+// on 64 bit and on little endian 32 bit platforms.
+func bufBytes(buf *uint64, length uint32) []byte {
+	if *buf == 0 {
+		return nil
+	}
+	return unsafe.Slice(*(**byte)(unsafe.Pointer(buf)), length)
+}
+
+// loopback fakes SPI_IOC_MESSAGE with MOSI connected to MISO
+// by copying the sent bytes of every transfer to its received bytes.
+func loopback(request uintptr, arg unsafe.Pointer) error {
+	xfers := transfers(request, arg)
+	for i := range xfers {
+		copy(bufBytes(&xfers[i].rx_buf, xfers[i].len), bufBytes(&xfers[i].tx_buf, xfers[i].len))
+	}
+	return nil
+}
+
+// fakeWrite replaces the write syscall with f until the end of the test.
+func fakeWrite(t *testing.T, f func(data []byte) (int, error)) {
+	saved := writeFile
+	writeFile = func(file *os.File, data []byte) (int, error) {
+		return f(data)
+	}
+	t.Cleanup(func() { writeFile = saved })
+}
+
+// fakeBufsiz sets the bufsiz module parameter of spidev
+// until the end of the test.
+func fakeBufsiz(t *testing.T, bufsiz string) {
+	path := filepath.Join(t.TempDir(), "bufsiz")
+	if err := os.WriteFile(path, []byte(bufsiz), 0660); err != nil {
+		t.Fatal(err)
+	}
+	saved := bufsizPath
+	bufsizPath = path
+	t.Cleanup(func() { bufsizPath = saved })
+}
+
+func TestWriteLarge(t *testing.T) {
+	fakeBufsiz(t, "64\n")
+	data := make([]byte, 250)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	tests := []struct {
+		name     string
+		chunk    int
+		short    bool // the first write transfers one byte less
+		chunks   []int
+		progress []int
+	}{
+		{"chunk", 50, false, []int{50, 50, 50, 50, 50}, []int{50, 100, 150, 200, 250}},
+		{"last chunk shorter", 100, false, []int{64, 64, 64, 58}, []int{64, 128, 192, 250}},
+		{"MaxTransferSize", 0, false, []int{64, 64, 64, 58}, []int{64, 128, 192, 250}},
+		{"short write", 50, true, []int{50, 50, 50, 50, 50, 1}, []int{49, 99, 149, 199, 249, 250}},
+	}
+	for _, test := range tests {
+		var chunks, progress []int
+		var written []byte
+		fakeWrite(t, func(chunk []byte) (int, error) {
+			chunks = append(chunks, len(chunk))
+			if test.short && len(chunks) == 1 {
+				chunk = chunk[:len(chunk)-1]
+			}
+			written = append(written, chunk...)
+			return len(chunk), nil
+		})
+		err := (&SPI{}).WriteLarge(data, test.chunk, func(done int) { progress = append(progress, done) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(chunks, test.chunks) || !reflect.DeepEqual(progress, test.progress) {
+			t.Errorf("%s: chunks %v and progress %v instead of %v and %v", test.name, chunks, progress, test.chunks, test.progress)
+		}
+		if string(written) != string(data) {
+			t.Errorf("%s: wrote different data", test.name)
+		}
+	}
+
+	writes := 0
+	fakeWrite(t, func(chunk []byte) (int, error) {
+		writes++
+		if writes == 2 {
+			return 0, syscall.EIO
+		}
+		return len(chunk), nil
+	})
+	if err := (&SPI{}).WriteLarge(data, 50, nil); !errors.Is(err, syscall.EIO) || writes != 2 {
+		t.Errorf("WriteLarge returned %v after %d writes instead of stopping at the failed write", err, writes)
+	}
+}
+
+func TestCheckWordAlignment(t *testing.T) {
+	tests := []struct {
+		bitsPerWord uint8
+		length      int
+		ok          bool
+	}{
+		{0, 0, false},
+		{0, 1, true},
+		{0, 3, true},
+		{8, 0, false},
+		{8, 1, true},
+		{8, 7, true},
+		{9, 1, false},
+		{9, 2, true},
+		{9, 3, false},
+		{9, 4, true},
+		{12, 2, true},
+		{12, 5, false},
+		{12, 6, true},
+		{15, 1, false},
+		{16, 0, false},
+		{16, 2, true},
+		{16, 3, false},
+		{16, 32, true},
+	}
+	for _, test := range tests {
+		spi := &SPI{bitsPerWord: test.bitsPerWord}
+		err := spi.checkWordAlignment(test.length)
+		if (err == nil) != test.ok {
+			t.Errorf("checkWordAlignment(%d) with %d bits per word returned %v", test.length, test.bitsPerWord, err)
+		}
+	}
+}
+
+func TestSpiIocMessage(t *testing.T) {
+	if size := unsafe.Sizeof(spi_ioc_transfer{}); size != 32 {
+		t.Fatalf("spi_ioc_transfer has %d bytes instead of 32", size)
+	}
+	tests := []struct {
+		n       int
+		request uintptr
+	}{
+		// values of SPI_IOC_MESSAGE(n) of the kernel headers
+		{1, 0x40206B00},
+		{2, 0x40406B00},
+		{3, 0x40606B00},
+		{511, 0x7FE06B00},
+		// the size of 512 transfers overflows the 14 bit size field
+		{512, 0},
+		{-1, 0},
+	}
+	for _, test := range tests {
+		if request := spiIocMessage(test.n); request != test.request {
+			t.Errorf("spiIocMessage(%d) = 0x%X instead of 0x%X", test.n, request, test.request)
+		}
+	}
+
+	fakeIoctl(t, func(request uintptr, arg unsafe.Pointer) error {
+		t.Fatal("message performed the ioctl with too many transfers")
+		return nil
+	})
+	if err := (&SPI{}).message(make([]spi_ioc_transfer, 512)); err == nil {
+		t.Error("message accepted 512 transfers")
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	fakeIoctl(t, loopback)
+	spi := &SPI{}
+
+	for _, n := range []int{-1, 0} {
+		if _, err := spi.ReadOnly(n, 0); err == nil {
+			t.Errorf("ReadOnly(%d) returned no error", n)
+		}
+	}
+	for _, n := range []int{4, 2, 16} {
+		rx, err := spi.ReadOnly(n, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rx) != n {
+			t.Errorf("ReadOnly(%d) returned %d bytes", n, len(rx))
+		}
+		for _, b := range rx {
+			if b != 0 {
+				t.Errorf("ReadOnly(%d) sent % X instead of zeros", n, rx)
+				break
+			}
+		}
+	}
+}
+
+// benchmarkLen is a variable like the lengths of real callers,
+// a constant would let the compiler allocate on the stack.
+var benchmarkLen = 4096
+
+func BenchmarkReadOnly(b *testing.B) {
+	fakeIoctl(b, loopback)
+	spi := &SPI{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := spi.ReadOnly(benchmarkLen, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkXfer2Zeros is the manual pattern replaced by ReadOnly.
+func BenchmarkXfer2Zeros(b *testing.B) {
+	fakeIoctl(b, loopback)
+	spi := &SPI{}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := spi.Xfer2(make([]byte, benchmarkLen), 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestXferWithCSSetup(t *testing.T) {
+	var xfers []spi_ioc_transfer
+	fakeIoctl(t, func(request uintptr, arg unsafe.Pointer) error {
+		xfers = append([]spi_ioc_transfer(nil), transfers(request, arg)...)
+		return loopback(request, arg)
+	})
+	spi := &SPI{}
+
+	for _, interByte := range []uint16{0, 5} {
+		xfers = nil
+		if _, err := spi.XferWithCSSetup(nil, 10, interByte); err == nil {
+			t.Errorf("XferWithCSSetup with interByteUsecs %d accepted an empty buffer", interByte)
+		}
+		if xfers != nil {
+			t.Errorf("XferWithCSSetup with interByteUsecs %d transferred an empty buffer", interByte)
+		}
+	}
+
+	rx, err := spi.XferWithCSSetup([]byte{1, 2, 3}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rx) != "\x01\x02\x03" {
+		t.Errorf("received % X", rx)
+	}
+	if len(xfers) != 2 || xfers[0].len != 0 || xfers[0].delay_usecs != 10 || xfers[1].len != 3 {
+		t.Errorf("transfers %+v, expected a 10us setup delay and one block", xfers)
+	}
+
+	_, err = spi.XferWithCSSetup([]byte{1, 2, 3}, 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(xfers) != 4 || xfers[0].delay_usecs != 10 || xfers[1].len != 1 || xfers[1].delay_usecs != 5 {
+		t.Errorf("transfers %+v, expected a 10us setup delay and single bytes", xfers)
+	}
+
+	// 16 bit words are split into 2 byte transfers
+	spi.bitsPerWord = 16
+	rx, err = spi.XferWithCSSetup([]byte{1, 2, 3, 4}, 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rx) != "\x01\x02\x03\x04" {
+		t.Errorf("received % X", rx)
+	}
+	if len(xfers) != 3 || xfers[0].delay_usecs != 10 {
+		t.Fatalf("transfers %+v, expected a 10us setup delay and two words", xfers)
+	}
+	for i, xfer := range xfers[1:] {
+		tx := bufBytes(&xfer.tx_buf, xfer.len)
+		if xfer.len != 2 || xfer.delay_usecs != 5 || tx[0] != byte(1+2*i) || tx[1] != byte(2+2*i) {
+			t.Errorf("transfer %d is %+v, expected word %d with a 5us delay", i+1, xfer, i)
+		}
+	}
+	if _, err = spi.XferWithCSSetup([]byte{1, 2, 3}, 10, 5); err == nil {
+		t.Error("XferWithCSSetup accepted half a 16 bit word")
+	}
+}
+
+func TestXferTrailingDelay(t *testing.T) {
+	var xfers []spi_ioc_transfer
+	fakeIoctl(t, func(request uintptr, arg unsafe.Pointer) error {
+		xfers = append([]spi_ioc_transfer(nil), transfers(request, arg)...)
+		return loopback(request, arg)
+	})
+	spi := &SPI{}
+
+	for _, interByte := range []uint16{0, 5} {
+		xfers = nil
+		if _, err := spi.XferTrailingDelay(nil, interByte, 20); err == nil {
+			t.Errorf("XferTrailingDelay with interByteUsecs %d accepted an empty buffer", interByte)
+		}
+		if xfers != nil {
+			t.Errorf("XferTrailingDelay with interByteUsecs %d transferred an empty buffer", interByte)
+		}
+	}
+
+	rx, err := spi.XferTrailingDelay([]byte{1, 2, 3}, 0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rx) != "\x01\x02\x03" {
+		t.Errorf("received % X", rx)
+	}
+	if len(xfers) != 2 || xfers[0].len != 3 || xfers[0].delay_usecs != 0 ||
+		xfers[1].len != 0 || xfers[1].delay_usecs != 20 {
+		t.Errorf("transfers %+v, expected one block and a 20us trailing delay", xfers)
+	}
+
+	tests := []struct {
+		bitsPerWord uint8
+		lengths     []uint32
+		delays      []uint16
+	}{
+		{8, []uint32{1, 1, 1, 1, 0}, []uint16{5, 5, 5, 0, 20}},
+		{16, []uint32{2, 2, 0}, []uint16{5, 0, 20}},
+		{32, []uint32{4, 0}, []uint16{0, 20}},
+	}
+	tx := []byte{1, 2, 3, 4}
+	for _, test := range tests {
+		spi.bitsPerWord = test.bitsPerWord
+		rx, err = spi.XferTrailingDelay(tx, 5, 20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(rx) != string(tx) {
+			t.Errorf("%d bits per word: received % X", test.bitsPerWord, rx)
+		}
+		if len(xfers) != len(test.lengths) {
+			t.Errorf("%d bits per word: transfers %+v, expected single words and a trailing delay", test.bitsPerWord, xfers)
+			continue
+		}
+		for i := range xfers {
+			if xfers[i].len != test.lengths[i] || xfers[i].delay_usecs != test.delays[i] {
+				t.Errorf("%d bits per word: transfer %d is %+v, expected %d bytes and a %dus delay",
+					test.bitsPerWord, i, xfers[i], test.lengths[i], test.delays[i])
+			}
+		}
+	}
+}
+
+// fakeSettings emulates the setting ioctls of spidev
+// by storing the written values under the number of the request.
+type fakeSettings struct {
+	values  map[uintptr][]byte
+	calls   int
+	ignored bool // ignore writes like a driver rejecting the value
+}
+
+func (f *fakeSettings) ioctl(request uintptr, arg unsafe.Pointer) error {
+	f.calls++
+	const iocWrite, iocRead = 1, 2
+	dir := request >> 30
+	nr := request & 0xFF
+	size := request >> 16 & (1<<14 - 1)
+	data := unsafe.Slice((*byte)(arg), size)
+	if f.values == nil {
+		f.values = make(map[uintptr][]byte)
+	}
+	switch {
+	case dir == iocWrite && !f.ignored:
+		f.values[nr] = append([]byte(nil), data...)
+	case dir == iocRead:
+		n := copy(data, f.values[nr])
+		for i := n; i < len(data); i++ {
+			data[i] = 0
+		}
+	}
+	return nil
+}
+
+func TestSetVerifyWrites(t *testing.T) {
+	for _, verify := range []bool{true, false} {
+		device := &fakeSettings{}
+		fakeIoctl(t, device.ioctl)
+		spi := &SPI{}
+		spi.SetVerifyWrites(verify)
+
+		if err := spi.SetMaxSpeedHz(1000000); err != nil {
+			t.Fatal(err)
+		}
+		if err := spi.SetBitsPerWord(16); err != nil {
+			t.Fatal(err)
+		}
+		if err := spi.SetMode(MODE_3); err != nil {
+			t.Fatal(err)
+		}
+		if spi.MaxSpeedHz() != 1000000 || spi.BitsPerWord() != 16 || spi.Mode() != MODE_3 {
+			t.Errorf("verify %t: settings are %d Hz, %d bits, mode %d", verify, spi.MaxSpeedHz(), spi.BitsPerWord(), spi.Mode())
+		}
+		expected := 3
+		if verify {
+			expected = 6
+		}
+		if device.calls != expected {
+			t.Errorf("verify %t: %d ioctls instead of %d", verify, device.calls, expected)
+		}
+
+		device.ignored = true
+		err := spi.SetMaxSpeedHz(500000)
+		if verify && err == nil {
+			t.Error("verification did not detect the ignored write")
+		}
+		if !verify && (err != nil || spi.MaxSpeedHz() != 500000) {
+			t.Errorf("without verification SetMaxSpeedHz = %v, %d Hz", err, spi.MaxSpeedHz())
+		}
+	}
+}
+
+// fakeRegisters fakes SPI_IOC_MESSAGE for a device answering register reads
+// with 0xA1, 0xA2, ... after the address byte, and records the sent bytes.
+func fakeRegisters(t *testing.T) (sent *[]byte) {
+	sent = new([]byte)
+	fakeIoctl(t, func(request uintptr, arg unsafe.Pointer) error {
+		xfers := transfers(request, arg)
+		if len(xfers) != 1 {
+			t.Errorf("register access with %d transfers instead of 1", len(xfers))
+		}
+		*sent = append([]byte(nil), bufBytes(&xfers[0].tx_buf, xfers[0].len)...)
+		rx := bufBytes(&xfers[0].rx_buf, xfers[0].len)
+		for i := 1; i < len(rx); i++ {
+			rx[i] = 0xA0 + byte(i)
+		}
+		return nil
+	})
+	return sent
+}
+
+func TestReadRegister(t *testing.T) {
+	sent := fakeRegisters(t)
+	spi := &SPI{}
+
+	tests := []struct {
+		reg, readBit uint8
+		n            int
+		sent         []byte
+	}{
+		{0x0F, 0x80, 1, []byte{0x8F, 0}},       // MSB selects a read
+		{0x28, 0xC0, 3, []byte{0xE8, 0, 0, 0}}, // MSB and auto-increment bit
+		{0x28, 0x80, 3, []byte{0xA8, 0, 0, 0}}, // no auto-increment
+		{0x8F, 0x80, 2, []byte{0x8F, 0, 0}},    // read bit already set
+		{0x05, 0x01, 1, []byte{0x05, 0}},       // LSB selects a read
+	}
+	for _, test := range tests {
+		rx, err := spi.ReadRegister(test.reg, test.readBit, test.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*sent, test.sent) {
+			t.Errorf("ReadRegister(%#x, %#x, %d) sent % X instead of % X", test.reg, test.readBit, test.n, *sent, test.sent)
+		}
+		expected := []byte{0xA1, 0xA2, 0xA3}[:test.n]
+		if !reflect.DeepEqual(rx, expected) {
+			t.Errorf("ReadRegister(%#x, %#x, %d) returned % X instead of % X", test.reg, test.readBit, test.n, rx, expected)
+		}
+	}
+
+	*sent = nil
+	for _, n := range []int{-1, 0} {
+		if _, err := spi.ReadRegister(0x0F, 0x80, n); err == nil {
+			t.Errorf("ReadRegister of %d bytes returned no error", n)
+		}
+	}
+	if *sent != nil {
+		t.Error("ReadRegister transferred an invalid length")
+	}
+}
+
+func TestWriteRegister(t *testing.T) {
+	sent := fakeRegisters(t)
+	spi := &SPI{}
+
+	tests := []struct {
+		reg  uint8
+		data []byte
+		sent []byte
+	}{
+		{0x20, []byte{0x47}, []byte{0x20, 0x47}},                // MSB cleared selects a write
+		{0x60, []byte{0x01, 0x02, 0x03}, []byte{0x60, 1, 2, 3}}, // auto-increment bit 0x40
+		{0x85, []byte{0xFF}, []byte{0x85, 0xFF}},                // a write bit is sent unchanged
+		{0x10, nil, []byte{0x10}},
+	}
+	for _, test := range tests {
+		if err := spi.WriteRegister(test.reg, test.data); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(*sent, test.sent) {
+			t.Errorf("WriteRegister(%#x, % X) sent % X instead of % X", test.reg, test.data, *sent, test.sent)
+		}
+	}
+
+	fakeIoctl(t, func(request uintptr, arg unsafe.Pointer) error { return syscall.EIO })
+	if err := spi.WriteRegister(0x20, []byte{1}); !errors.Is(err, syscall.EIO) {
+		t.Errorf("WriteRegister returned %v instead of the transfer error", err)
+	}
+}
+
+func TestSetCSActiveHigh(t *testing.T) {
+	const modeNr, mode32Nr = 1, 5
+	device := &fakeSettings{values: map[uintptr][]byte{
+		mode32Nr: {byte(CPHA), TX_DUAL >> 8, 0, 0},
+	}}
+	fakeIoctl(t, device.ioctl)
+	spi := &SPI{mode: CPHA}
+
+	for _, activeHigh := range []bool{true, false, true} {
+		if err := spi.SetCSActiveHigh(activeHigh); err != nil {
+			t.Fatal(err)
+		}
+		expected := []byte{CPHA, TX_DUAL >> 8, 0, 0}
+		if activeHigh {
+			expected[0] |= CS_HIGH
+		}
+		if !reflect.DeepEqual(device.values[mode32Nr], expected) {
+			t.Errorf("SetCSActiveHigh(%t) wrote mode % X instead of % X", activeHigh, device.values[mode32Nr], expected)
+		}
+		if spi.CSActiveHigh() != activeHigh || spi.CSHigh() != activeHigh || spi.Mode() != MODE_1 {
+			t.Errorf("SetCSActiveHigh(%t) changed the mode to %X", activeHigh, spi.mode)
+		}
+	}
+
+	device.ignored = true
+	if err := spi.SetCSActiveHigh(false); err == nil {
+		t.Error("SetCSActiveHigh did not detect the ignored write")
+	}
+	if !spi.CSActiveHigh() {
+		t.Error("SetCSActiveHigh changed the mode after the failed write")
+	}
+
+	// kernels without the 32 bit mode ioctls
+	device = &fakeSettings{}
+	fakeIoctl(t, func(request uintptr, arg unsafe.Pointer) error {
+		if request&0xFF == mode32Nr {
+			return syscall.ENOTTY
+		}
+		return device.ioctl(request, arg)
+	})
+	spi = &SPI{mode: CPHA}
+	if err := spi.SetCSActiveHigh(true); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{CPHA | CS_HIGH}; !reflect.DeepEqual(device.values[modeNr], expected) || !spi.CSActiveHigh() {
+		t.Errorf("SetCSActiveHigh without 32 bit mode wrote % X instead of % X", device.values[modeNr], expected)
+	}
+}
+
+func TestXferInPlace(t *testing.T) {
+	var xfers []spi_ioc_transfer
+	fakeIoctl(t, func(request uintptr, arg unsafe.Pointer) error {
+		xfers = append([]spi_ioc_transfer(nil), transfers(request, arg)...)
+		// the device answers with the inverted sent bytes
+		for i := range xfers {
+			rx := bufBytes(&xfers[i].rx_buf, xfers[i].len)
+			for j, b := range bufBytes(&xfers[i].tx_buf, xfers[i].len) {
+				rx[j] = ^b
+			}
+		}
+		return nil
+	})
+	spi := &SPI{}
+
+	buf := []byte{0x00, 0x0F, 0xA5, 0xFF}
+	if err := spi.XferInPlace(buf, 7); err != nil {
+		t.Fatal(err)
+	}
+	if len(xfers) != 1 {
+		t.Fatalf("XferInPlace performed %d transfers instead of 1", len(xfers))
+	}
+	xfer := xfers[0]
+	if xfer.tx_buf != xfer.rx_buf || xfer.tx_buf != uint64(uintptr(unsafe.Pointer(&buf[0]))) {
+		t.Error("XferInPlace did not send and receive in buf")
+	}
+	if xfer.len != 4 || xfer.delay_usecs != 7 {
+		t.Errorf("XferInPlace transfer of %d bytes with %d usecs delay", xfer.len, xfer.delay_usecs)
+	}
+	if expected := []byte{0xFF, 0xF0, 0x5A, 0x00}; !reflect.DeepEqual(buf, expected) {
+		t.Errorf("XferInPlace received % X instead of % X", buf, expected)
+	}
+
+	fakeIoctl(t, loopback)
+	buf = []byte{1, 2, 3}
+	if err := spi.XferInPlace(buf, 0); err != nil || !reflect.DeepEqual(buf, []byte{1, 2, 3}) {
+		t.Errorf("XferInPlace in loopback received % X, %v", buf, err)
+	}
+	if err := spi.XferInPlace(nil, 0); err == nil {
+		t.Error("XferInPlace accepted an empty buffer")
+	}
+}
+
+func TestDeassertCS(t *testing.T) {
+	var events []string
+	fakeIoctl(t, func(request uintptr, arg unsafe.Pointer) error {
+		events = append(events, "message")
+		return loopback(request, arg)
+	})
+	var readErr error
+	saved := readZero
+	readZero = func(fd uintptr) error {
+		events = append(events, "read")
+		return readErr
+	}
+	t.Cleanup(func() { readZero = saved })
+	spi := &SPI{}
+
+	rx, err := spi.Xfer2([]byte{1, 2, 3}, 0)
+	if err != nil || !reflect.DeepEqual(rx, []byte{1, 2, 3}) {
+		t.Fatalf("Xfer2 = % X, %v", rx, err)
+	}
+	if err = spi.DeassertCS(); err != nil {
+		t.Fatal(err)
+	}
+	if err = spi.DeassertCS(); err != nil {
+		t.Fatal(err)
+	}
+	// the transfers after an explicit deassert are not affected
+	rx, err = spi.Xfer2([]byte{4, 5}, 0)
+	if err != nil || !reflect.DeepEqual(rx, []byte{4, 5}) {
+		t.Errorf("Xfer2 after DeassertCS = % X, %v", rx, err)
+	}
+	buf := []byte{6, 7, 8, 9}
+	if err = spi.XferInPlace(buf, 0); err != nil || !reflect.DeepEqual(buf, []byte{6, 7, 8, 9}) {
+		t.Errorf("XferInPlace after DeassertCS = % X, %v", buf, err)
+	}
+	expected := []string{"message", "read", "read", "read", "message", "read", "message", "read"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("syscalls %v instead of %v", events, expected)
+	}
+
+	// the workaround after a transfer ignores a failed read, DeassertCS returns it
+	readErr = syscall.EIO
+	if _, err = spi.Xfer2([]byte{1}, 0); err != nil {
+		t.Errorf("Xfer2 returned the error %v of the deassert read", err)
+	}
+	if err = spi.DeassertCS(); !errors.Is(err, syscall.EIO) {
+		t.Errorf("DeassertCS returned %v instead of the read error", err)
+	}
+}
+
+// BenchmarkSetMaxSpeedHz reports the ioctls per call
+// with and without the read-back.
+func BenchmarkSetMaxSpeedHz(b *testing.B) {
+	for _, verify := range []bool{true, false} {
+		name := "verify"
+		if !verify {
+			name = "noverify"
+		}
+		b.Run(name, func(b *testing.B) {
+			device := &fakeSettings{}
+			fakeIoctl(b, device.ioctl)
+			spi := &SPI{}
+			spi.SetVerifyWrites(verify)
+			speeds := []uint32{1000000, 8000000}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := spi.SetMaxSpeedHz(speeds[i%2]); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(device.calls)/float64(b.N), "ioctls/op")
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package spitest_test
+
+import (
+	"fmt"
+
+	"github.com/SpaceLeap/go-embedded/spi"
+	"github.com/SpaceLeap/go-embedded/spi/spitest"
+)
+
+// readWhoAmI is the driver code under test, it reads the WHO_AM_I
+// register 0x0F of a sensor using the MSB as read bit.
+func readWhoAmI(transport spi.Transport) (uint8, error) {
+	rx, err := transport.Xfer2([]byte{0x8F, 0x00}, 0)
+	if err != nil {
+		return 0, err
+	}
+	return rx[1], nil
+}
+
+func ExampleFake() {
+	fake := spitest.NewFake()
+	fake.Expect([]byte{0x8F, 0x00}).Return([]byte{0x00, 0x33})
+
+	id, err := readWhoAmI(fake)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("WHO_AM_I: 0x%02X\n", id)
+	fmt.Println("expectations met:", fake.ExpectationsWereMet() == nil)
+	// Output:
+	// WHO_AM_I: 0x33
+	// expectations met: true
+}
@@ -0,0 +1,131 @@
+// Package spitest provides a scripted fake implementation of spi.Transport
+// for testing SPI command sequences without hardware,
+// see the example of Fake for a register read.
+package spitest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/SpaceLeap/go-embedded/spi"
+)
+
+var ErrClosed = errors.New("spitest: Fake is closed")
+
+// Expectation is a scripted transfer of a Fake.
+type Expectation struct {
+	tx []byte
+	rx []byte
+}
+
+// Return sets the bytes received by the expected transfer.
+// Without Return zeros are received.
+func (e *Expectation) Return(rx []byte) *Expectation {
+	e.rx = append([]byte(nil), rx...)
+	return e
+}
+
+// Fake implements spi.Transport by checking every transfer
+// against the next expectation scripted with Expect.
+// Read is handled as transfer of len(data) zero bytes.
+type Fake struct {
+	mutex        sync.Mutex
+	expectations []*Expectation
+	written      [][]byte
+	err          error
+	closed       bool
+}
+
+var _ spi.Transport = &Fake{}
+
+func NewFake() *Fake {
+	return &Fake{}
+}
+
+// Expect adds the expectation of a transfer sending tx.
+func (fake *Fake) Expect(tx []byte) *Expectation {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	e := &Expectation{tx: append([]byte(nil), tx...)}
+	fake.expectations = append(fake.expectations, e)
+	return e
+}
+
+// Written returns the bytes sent by all transfers so far.
+func (fake *Fake) Written() [][]byte {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return append([][]byte(nil), fake.written...)
+}
+
+// ExpectationsWereMet returns an error if a transfer didn't match
+// its expectation or if expectations are left over.
+func (fake *Fake) ExpectationsWereMet() error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.err != nil {
+		return fake.err
+	}
+	if n := len(fake.expectations); n > 0 {
+		return fmt.Errorf("spitest: %d expected transfers left, next % X", n, fake.expectations[0].tx)
+	}
+	return nil
+}
+
+func (fake *Fake) transfer(tx []byte) ([]byte, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.closed {
+		return nil, ErrClosed
+	}
+	fake.written = append(fake.written, append([]byte(nil), tx...))
+	if len(fake.expectations) == 0 {
+		err := fmt.Errorf("spitest: unexpected transfer % X", tx)
+		if fake.err == nil {
+			fake.err = err
+		}
+		return nil, err
+	}
+	e := fake.expectations[0]
+	fake.expectations = fake.expectations[1:]
+	if !bytes.Equal(tx, e.tx) {
+		err := fmt.Errorf("spitest: transfer % X, expected % X", tx, e.tx)
+		if fake.err == nil {
+			fake.err = err
+		}
+		return nil, err
+	}
+	rx := make([]byte, len(tx))
+	copy(rx, e.rx)
+	return rx, nil
+}
+
+func (fake *Fake) Xfer2(txBuf []byte, delay_usecs uint16) ([]byte, error) {
+	return fake.transfer(txBuf)
+}
+
+func (fake *Fake) Read(data []byte) (int, error) {
+	rx, err := fake.transfer(make([]byte, len(data)))
+	if err != nil {
+		return 0, err
+	}
+	return copy(data, rx), nil
+}
+
+func (fake *Fake) Write(data []byte) (int, error) {
+	_, err := fake.transfer(data)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// Close makes all following transfers fail with ErrClosed.
+func (fake *Fake) Close() error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.closed = true
+	return nil
+}
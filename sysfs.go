@@ -0,0 +1,108 @@
+package embedded
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sysfsMaxSize is the maximum size of a sysfs attribute, one page.
+const sysfsMaxSize = 4096
+
+// ReadSysfsString reads the sysfs attribute at path
+// and returns its content with surrounding whitespace trimmed.
+func ReadSysfsString(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("sysfs read: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// WriteSysfsString writes value to the sysfs attribute at path.
+func WriteSysfsString(path, value string) error {
+	err := ioutil.WriteFile(path, []byte(value), 0660)
+	if err != nil {
+		return fmt.Errorf("sysfs write: %w", err)
+	}
+	return nil
+}
+
+// ReadSysfsInt reads the sysfs attribute at path as decimal integer.
+func ReadSysfsInt(path string) (int, error) {
+	str, err := ReadSysfsString(path)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("sysfs read %s: %w", path, err)
+	}
+	return value, nil
+}
+
+// WriteSysfsInt writes value as decimal integer to the sysfs attribute at path.
+func WriteSysfsInt(path string, value int) error {
+	return WriteSysfsString(path, strconv.Itoa(value))
+}
+
+// ReadSysfsFileString reads the open sysfs attribute file from the start
+// and returns its content with surrounding whitespace trimmed,
+// for attributes that are read repeatedly without reopening them.
+// The file is read with pread at offset 0, so its file offset
+// is not used and concurrent reads don't interfere.
+func ReadSysfsFileString(file *os.File) (string, error) {
+	data, err := ioutil.ReadAll(io.NewSectionReader(file, 0, sysfsMaxSize))
+	if err != nil {
+		return "", fmt.Errorf("sysfs read: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ReadSysfsFileInt reads the open sysfs attribute file
+// as decimal integer, see ReadSysfsFileString.
+func ReadSysfsFileInt(file *os.File) (int, error) {
+	str, err := ReadSysfsFileString(file)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.Atoi(str)
+	if err != nil {
+		return 0, fmt.Errorf("sysfs read %s: %w", file.Name(), err)
+	}
+	return value, nil
+}
+
+// ReadSysfsFileInt64 reads the open sysfs attribute file
+// as 64 bit decimal integer, see ReadSysfsFileString.
+func ReadSysfsFileInt64(file *os.File) (int64, error) {
+	str, err := ReadSysfsFileString(file)
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("sysfs read %s: %w", file.Name(), err)
+	}
+	return value, nil
+}
+
+// WriteSysfsFileString writes value to the open sysfs attribute file
+// with pwrite at offset 0, for attributes that are written repeatedly
+// without reopening them.
+func WriteSysfsFileString(file *os.File, value string) error {
+	_, err := file.WriteAt([]byte(value), 0)
+	if err != nil {
+		return fmt.Errorf("sysfs write: %w", err)
+	}
+	return nil
+}
+
+// WriteSysfsFileInt64 writes value as decimal integer
+// to the open sysfs attribute file, see WriteSysfsFileString.
+func WriteSysfsFileInt64(file *os.File, value int64) error {
+	return WriteSysfsFileString(file, strconv.FormatInt(value, 10))
+}
@@ -0,0 +1,97 @@
+package embedded
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "attribute")
+	if err := os.WriteFile(path, []byte(content), 0660); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadSysfsInt(t *testing.T) {
+	tests := []struct {
+		content string
+		value   int
+		ok      bool
+	}{
+		{"42\n", 42, true},
+		{"  -7 \n", -7, true},
+		{"0", 0, true},
+		{"4095\n", 4095, true},
+		{"", 0, false},
+		{"12a\n", 0, false},
+		{"1.5\n", 0, false},
+	}
+	for _, test := range tests {
+		path := writeTestFile(t, test.content)
+
+		value, err := ReadSysfsInt(path)
+		if (err == nil) != test.ok || value != test.value {
+			t.Errorf("ReadSysfsInt(%q) = %d, %v", test.content, value, err)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// read twice to check that the file offset isn't used
+		for i := 0; i < 2; i++ {
+			value, err = ReadSysfsFileInt(file)
+			if (err == nil) != test.ok || value != test.value {
+				t.Errorf("ReadSysfsFileInt(%q) = %d, %v", test.content, value, err)
+			}
+			value64, err := ReadSysfsFileInt64(file)
+			if (err == nil) != test.ok || value64 != int64(test.value) {
+				t.Errorf("ReadSysfsFileInt64(%q) = %d, %v", test.content, value64, err)
+			}
+		}
+		file.Close()
+	}
+}
+
+func TestReadSysfsString(t *testing.T) {
+	path := writeTestFile(t, " in\n")
+	str, err := ReadSysfsString(path)
+	if err != nil || str != "in" {
+		t.Errorf("ReadSysfsString = %q, %v", str, err)
+	}
+
+	_, err = ReadSysfsString(filepath.Join(t.TempDir(), "missing"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ReadSysfsString of missing file returned %v", err)
+	}
+}
+
+func TestWriteSysfs(t *testing.T) {
+	path := writeTestFile(t, "")
+	if err := WriteSysfsInt(path, 1000000); err != nil {
+		t.Fatal(err)
+	}
+	value, err := ReadSysfsInt(path)
+	if err != nil || value != 1000000 {
+		t.Errorf("ReadSysfsInt after WriteSysfsInt = %d, %v", value, err)
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	// every write starts at offset 0 like a sysfs store
+	for _, v := range []int64{5000000, 7000000} {
+		if err := WriteSysfsFileInt64(file, v); err != nil {
+			t.Fatal(err)
+		}
+		value64, err := ReadSysfsFileInt64(file)
+		if err != nil || value64 != v {
+			t.Errorf("ReadSysfsFileInt64 after WriteSysfsFileInt64(%d) = %d, %v", v, value64, err)
+		}
+	}
+}